@@ -24,6 +24,7 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
+  const isPublic = document.getElementById('video-public').checked;
 
   try {
     const res = await fetch('/api/videos', {
@@ -32,7 +33,7 @@ async function createVideoDraft() {
         'Content-Type': 'application/json',
         Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
-      body: JSON.stringify({ title, description }),
+      body: JSON.stringify({ title, description, public: isPublic }),
     });
     const data = await res.json();
     if (!res.ok) {
@@ -170,12 +171,17 @@ async function uploadVideoFile(videoID) {
       },
       body: formData,
     });
+    const data = await res.json();
     if (!res.ok) {
-      const data = await res.json();
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log('Video uploaded!');
+    console.log('Video uploaded, processing...');
+    const job = await waitForJob(data.id);
+    if (job.status === 'failed') {
+      throw new Error(`Failed to process video file. Error: ${job.error}`);
+    }
+    console.log('Video processed!');
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -184,11 +190,50 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function waitForJob(jobID) {
+  while (true) {
+    const res = await fetch(`/api/jobs/${jobID}`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    const job = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to get processing status. Error: ${job.error}`);
+    }
+    if (job.status === 'succeeded' || job.status === 'failed') {
+      return job;
+    }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
+let nextVideosCursor = null;
+
+// getVideos reloads the list from the first page; loadMoreVideos appends the
+// page after the last one shown.
 async function getVideos() {
+  document.getElementById('video-list').innerHTML = '';
+  nextVideosCursor = null;
+  await fetchVideosPage();
+}
+
+async function loadMoreVideos() {
+  if (nextVideosCursor) {
+    await fetchVideosPage(nextVideosCursor);
+  }
+}
+
+async function fetchVideosPage(cursor) {
   try {
-    const res = await fetch('/api/videos', {
+    const params = new URLSearchParams();
+    if (cursor) {
+      params.set('cursor', cursor);
+    }
+    const res = await fetch(`/api/videos?${params}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,15 +244,17 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const page = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
-    for (const video of videos) {
+    for (const video of page.videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+
+    nextVideosCursor = page.next_cursor;
+    document.getElementById('load-more-videos').style.display = nextVideosCursor ? 'block' : 'none';
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -262,6 +309,8 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    const jpegVariants = (video.thumbnail_variants || []).filter((v) => v.content_type === 'image/jpeg');
+    thumbnailImg.srcset = jpegVariants.map((v) => `${v.url} ${v.width}w`).join(', ');
   }
 
   const videoPlayer = document.getElementById('video-player');
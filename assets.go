@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"strings"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +11,16 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// getObjectKey returns the blob store key for a stored video_url, hls_url or
+// dash_url value. New rows hold the key itself; rows written before URLs were
+// presigned hold a full public URL.
+func (cfg apiConfig) getObjectKey(stored string) (string, bool) {
+	if !strings.Contains(stored, "://") {
+		return stored, stored != ""
+	}
+	if cfg.storageBackend != "s3" {
+		return cfg.urls.AssetKey(stored)
+	}
+	return cfg.urls.ObjectKey(stored)
+}
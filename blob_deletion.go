@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+const (
+	blobStoreName  = "blob"
+	assetStoreName = "asset"
+
+	blobDeleteAttempts      = 3
+	pendingDeletionBatch    = 100
+	pendingDeletionInterval = time.Minute
+	// A pending deletion is retried with doubling delays up to
+	// maxPendingDeletionBackoff, and left alone after
+	// maxPendingDeletionAttempts failures.
+	maxPendingDeletionAttempts = 10
+	maxPendingDeletionBackoff  = 6 * time.Hour
+)
+
+func (cfg *apiConfig) storeByName(name string) (storage.BlobStore, error) {
+	switch name {
+	case blobStoreName:
+		return cfg.blobStore, nil
+	case assetStoreName:
+		return cfg.assetStore, nil
+	}
+	return nil, fmt.Errorf("unknown blob store %q", name)
+}
+
+// videoBlobRefs is every object a video references.
+func (cfg *apiConfig) videoBlobRefs(video database.Video) []database.BlobRef {
+	return append(cfg.videoContentRefs(video), cfg.videoOwnRefs(video)...)
+}
+
+// videoContentRefs are the processed outputs, which videos uploaded with the
+// same bytes share through the content index.
+func (cfg *apiConfig) videoContentRefs(video database.Video) []database.BlobRef {
+	refs := []database.BlobRef{}
+	if video.VideoURL != nil {
+		if key, ok := cfg.getObjectKey(*video.VideoURL); ok {
+			refs = append(refs, database.BlobRef{Store: blobStoreName, Key: key})
+		}
+	}
+	// Streaming manifests sit at the root of a per-video prefix that also
+	// holds every segment.
+	for _, manifestURL := range []*string{video.HLSURL, video.DASHURL} {
+		if manifestURL == nil {
+			continue
+		}
+		if key, ok := cfg.getObjectKey(*manifestURL); ok {
+			refs = append(refs, database.BlobRef{Store: blobStoreName, Key: path.Dir(key) + "/"})
+		}
+	}
+	return refs
+}
+
+// videoOwnRefs are the objects that belong to this video alone: thumbnails
+// and the retained original.
+func (cfg *apiConfig) videoOwnRefs(video database.Video) []database.BlobRef {
+	refs := []database.BlobRef{}
+	if video.ThumbnailURL != nil {
+		if ref, ok := cfg.thumbnailRef(*video.ThumbnailURL); ok {
+			refs = append(refs, ref)
+		}
+	}
+	for _, variant := range video.ThumbnailVariants {
+		if ref, ok := cfg.thumbnailRef(variant.URL); ok {
+			refs = append(refs, ref)
+		}
+	}
+	refs = append(refs, database.BlobRef{Store: blobStoreName, Key: thumbnailCandidateDir(video.ID)})
+	refs = append(refs, database.BlobRef{Store: blobStoreName, Key: originalsDir(video.ID)})
+	return refs
+}
+
+// thumbnailRef locates a stored thumbnail_url, variant or candidate URL.
+// Current rows hold a blob store key; rows written before thumbnails moved to
+// the bucket hold a full /assets/ URL on the local asset store.
+func (cfg *apiConfig) thumbnailRef(stored string) (database.BlobRef, bool) {
+	if strings.Contains(stored, "://") {
+		key, ok := cfg.urls.AssetKey(stored)
+		return database.BlobRef{Store: assetStoreName, Key: key}, ok
+	}
+	if stored == "" || strings.HasPrefix(stored, "data:") {
+		return database.BlobRef{}, false
+	}
+	return database.BlobRef{Store: blobStoreName, Key: stored}, true
+}
+
+// notifyPendingDeletions wakes retryPendingDeletions to delete blobs that
+// were just recorded, rather than leaving them until the next tick.
+func (cfg *apiConfig) notifyPendingDeletions() {
+	select {
+	case cfg.deletionNotify <- struct{}{}:
+	default:
+	}
+}
+
+// deleteBlobs tries to remove each pending blob, clearing its record on
+// success. Failures stay in pending_deletions and are retried later.
+func (cfg *apiConfig) deleteBlobs(ctx context.Context, pending []database.PendingDeletion) {
+	for _, deletion := range pending {
+		err := cfg.deleteBlob(ctx, deletion.BlobRef)
+		if err != nil {
+			attempts := deletion.Attempts + 1
+			log.Printf("Couldn't delete %s object %s (attempt %d): %v", deletion.Store, deletion.Key, attempts, err)
+			if attempts >= maxPendingDeletionAttempts {
+				log.Printf("Giving up on deleting %s object %s; it stays in pending_deletions", deletion.Store, deletion.Key)
+			}
+			nextAttemptAt := time.Now().Add(pendingDeletionBackoff(attempts))
+			if err := cfg.db.RecordPendingDeletionFailure(deletion.ID, err, nextAttemptAt); err != nil {
+				log.Printf("Couldn't record failed deletion of %s: %v", deletion.Key, err)
+			}
+			continue
+		}
+		if err := cfg.db.DeletePendingDeletion(deletion.ID); err != nil {
+			log.Printf("Couldn't clear pending deletion of %s: %v", deletion.Key, err)
+		}
+	}
+}
+
+// deleteBlob removes a single object, or every object under the prefix when
+// the key ends in a slash.
+func (cfg *apiConfig) deleteBlob(ctx context.Context, ref database.BlobRef) error {
+	store, err := cfg.storeByName(ref.Store)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(ref.Key, "/") {
+		objects, err := store.List(ctx, ref.Key)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			err := cfg.deleteBlob(ctx, database.BlobRef{Store: ref.Store, Key: obj.Key})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = store.Delete(ctx, ref.Key)
+		if err == nil || attempt == blobDeleteAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// pendingDeletionBackoff is how long to wait after a deletion has failed
+// attempts times.
+func pendingDeletionBackoff(attempts int) time.Duration {
+	backoff := pendingDeletionInterval
+	for i := 1; i < attempts && backoff < maxPendingDeletionBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxPendingDeletionBackoff)
+}
+
+// retryPendingDeletions deletes recorded blobs in the background: whenever
+// notifyPendingDeletions is called and on every tick for retries.
+func (cfg *apiConfig) retryPendingDeletions(ctx context.Context) {
+	ticker := time.NewTicker(pendingDeletionInterval)
+	defer ticker.Stop()
+
+	for {
+		pending, err := cfg.db.GetPendingDeletions(pendingDeletionBatch, maxPendingDeletionAttempts, time.Now())
+		if err != nil {
+			log.Printf("Couldn't load pending deletions: %v", err)
+		} else {
+			cfg.deleteBlobs(ctx, pending)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.deletionNotify:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingDeletionBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, maxPendingDeletionBackoff},
+		{1000, maxPendingDeletionBackoff},
+	}
+	for _, tt := range tests {
+		if got := pendingDeletionBackoff(tt.attempts); got != tt.want {
+			t.Errorf("pendingDeletionBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runCommand handles one-off maintenance subcommands given on the command
+// line, run instead of starting the server.
+func (cfg *apiConfig) runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "migrate-thumbnails":
+		flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only log what would be moved")
+		flags.Parse(args[1:])
+		return cfg.migrateThumbnails(ctx, *dryRun)
+	}
+	return fmt.Errorf("unknown command %q, expected migrate or migrate-thumbnails", args[0])
+}
+
+// runMigrateCommand applies, rolls back or lists schema migrations:
+//
+//	migrate up
+//	migrate down [-steps n]
+//	migrate status
+func runMigrateCommand(dbURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs up, down or status")
+	}
+	db, err := database.Open(dbURL)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+		return err
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "how many migrations to roll back")
+		flags.Parse(args[1:])
+		rolledBack, err := db.MigrateDown(*steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		states, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.AppliedAt != nil {
+				status = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const dashPrefix = "dash/"
+
+// processVideoForDASH encodes the same ladder as HLS into fragmented MP4
+// segments with a single MPD manifest, uploads them under dash/<outputID>/ and
+// returns the manifest key.
+func (cfg *apiConfig) processVideoForDASH(ctx context.Context, outputID uuid.UUID, filePath string, probe videoProbe) (string, error) {
+	outputDir, err := os.MkdirTemp(cfg.uploadsRoot, "dash-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outputDir)
+
+	renditions := ladderFor(cfg.hlsLadder, probe)
+
+	args := []string{"-i", filePath}
+	for range renditions {
+		args = append(args, "-map", "0:v:0")
+	}
+	adaptationSets := "id=0,streams=v"
+	if probe.HasAudio {
+		args = append(args, "-map", "0:a:0")
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+	)
+	for i, r := range renditions {
+		width, height := r.size(probe)
+		stream := strconv.Itoa(i)
+		args = append(args,
+			"-filter:v:"+stream, fmt.Sprintf("scale=%d:%d", width, height),
+			"-b:v:"+stream, strconv.Itoa(r.VideoBitrate),
+			"-maxrate:v:"+stream, strconv.Itoa(r.VideoBitrate*107/100),
+			"-bufsize:v:"+stream, strconv.Itoa(r.VideoBitrate*3/2),
+		)
+	}
+	if probe.HasAudio {
+		args = append(args,
+			"-c:a", "aac",
+			"-b:a", strconv.Itoa(renditions[0].AudioBitrate),
+			"-ac", "2",
+		)
+	}
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", "6",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		filepath.Join(outputDir, "manifest.mpd"),
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ffmpeg DASH encode failed with error: %v\n and stderr: %s", err, stderr.String())
+	}
+
+	prefix := dashPrefix + outputID.String() + "/"
+	err = cfg.putDirectory(ctx, outputDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "manifest.mpd", nil
+}
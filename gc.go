@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+var videoPrefixes = []string{"landscape/", "portrait/", "other/", hlsPrefix, dashPrefix, incomingPrefix, originalsPrefix, thumbnailVariantPrefix}
+
+// gcRunning keeps the background sweeper and /admin/gc from racing.
+var gcRunning sync.Mutex
+
+type gcObject struct {
+	Store string `json:"store"`
+	storage.ObjectInfo
+}
+
+type gcReport struct {
+	DryRun         bool       `json:"dry_run"`
+	StartedAt      time.Time  `json:"started_at"`
+	Scanned        int        `json:"scanned"`
+	Removed        []gcObject `json:"removed"`
+	AbortedUploads int        `json:"aborted_uploads"`
+	// ExpiredUploads are resumable uploads dropped for inactivity, and
+	// RemovedUploadFiles what was left in the uploads directory with
+	// nothing tracking it.
+	ExpiredUploads     int      `json:"expired_uploads"`
+	RemovedUploadFiles []string `json:"removed_upload_files"`
+	Errors             []string `json:"errors"`
+}
+
+// incompleteUploadAborter is implemented by stores that can leave behind
+// unfinished multipart uploads.
+type incompleteUploadAborter interface {
+	AbortIncompleteUploads(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// collectGarbage deletes objects that neither a video row nor the content
+// index references and that are older than the grace period. Outputs a
+// running job has uploaded but not yet registered are only kept by the
+// grace period, which is why it can't be shorter than the job timeout.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, dryRun bool) (gcReport, error) {
+	report := gcReport{
+		DryRun:             dryRun,
+		StartedAt:          time.Now().UTC(),
+		Removed:            []gcObject{},
+		RemovedUploadFiles: []string{},
+		Errors:             []string{},
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return report, err
+	}
+	// Keys ending in a slash reference everything below them.
+	referenced := map[string]bool{}
+	for _, video := range videos {
+		for _, ref := range cfg.videoBlobRefs(video) {
+			referenced[ref.Key] = true
+		}
+	}
+	// A job reusing an entry holds a reference before its video row points
+	// at the outputs.
+	contents, err := cfg.db.GetAllContent()
+	if err != nil {
+		return report, err
+	}
+	for _, content := range contents {
+		for _, ref := range cfg.videoContentRefs(contentVideo(content)) {
+			referenced[ref.Key] = true
+		}
+	}
+
+	cutoff := report.StartedAt.Add(-cfg.gcGracePeriod)
+
+	sweep := func(storeName string, store storage.BlobStore, prefix string, skipPrefixes []string) error {
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if hasAnyPrefix(obj.Key, skipPrefixes) {
+				continue
+			}
+			report.Scanned++
+			if isReferenced(referenced, obj.Key) || obj.LastModified.After(cutoff) {
+				continue
+			}
+			if !dryRun {
+				if err := store.Delete(ctx, obj.Key); err != nil {
+					report.Errors = append(report.Errors, err.Error())
+					continue
+				}
+			}
+			report.Removed = append(report.Removed, gcObject{Store: storeName, ObjectInfo: obj})
+		}
+		return nil
+	}
+
+	for _, prefix := range videoPrefixes {
+		if err := sweep(blobStoreName, cfg.blobStore, prefix, nil); err != nil {
+			return report, err
+		}
+	}
+
+	// With the local backend videos and thumbnails share one directory, so
+	// the asset sweep must not count the video prefixes a second time.
+	var skipPrefixes []string
+	if cfg.assetStore == cfg.blobStore {
+		skipPrefixes = videoPrefixes
+	}
+	if err := sweep(assetStoreName, cfg.assetStore, "", skipPrefixes); err != nil {
+		return report, err
+	}
+
+	if aborter, ok := cfg.blobStore.(incompleteUploadAborter); ok && !dryRun {
+		aborted, err := aborter.AbortIncompleteUploads(ctx, cutoff)
+		report.AbortedUploads = aborted
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	if err := cfg.collectUploads(&report, cutoff); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// collectUploads drops expired resumable uploads, then clears the uploads
+// directory of anything older than cutoff that nothing tracks any more:
+// partial uploads whose row is gone, inputs of finished jobs and scratch
+// directories left behind by a crash.
+func (cfg *apiConfig) collectUploads(report *gcReport, cutoff time.Time) error {
+	if cfg.uploadExpiry > 0 {
+		expired, err := cfg.db.GetUploadsUpdatedBefore(report.StartedAt.Add(-cfg.uploadExpiry))
+		if err != nil {
+			return err
+		}
+		for _, upload := range expired {
+			if report.DryRun || cfg.expireUpload(upload.ID) {
+				report.ExpiredUploads++
+			}
+		}
+	}
+
+	inputPaths, err := cfg.db.GetUnfinishedJobInputPaths()
+	if err != nil {
+		return err
+	}
+	inUse := map[string]bool{}
+	for _, inputPath := range inputPaths {
+		inUse[filepath.Clean(inputPath)] = true
+	}
+
+	entries, err := os.ReadDir(cfg.uploadsRoot)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(cfg.uploadsRoot, entry.Name())
+		if inUse[entryPath] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if uploadID, err := uuid.Parse(entry.Name()); err == nil {
+			upload, err := cfg.db.GetUpload(uploadID)
+			if err != nil {
+				return err
+			}
+			// Live uploads expire through their row instead, and a dry run
+			// leaves the expired ones in place.
+			if upload.ID != uuid.Nil {
+				continue
+			}
+		}
+		if !report.DryRun {
+			if err := os.RemoveAll(entryPath); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.RemovedUploadFiles = append(report.RemovedUploadFiles, entry.Name())
+	}
+	return nil
+}
+
+func isReferenced(referenced map[string]bool, key string) bool {
+	if referenced[key] {
+		return true
+	}
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if referenced[dir+"/"] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) runGarbageCollector(ctx context.Context) {
+	ticker := time.NewTicker(cfg.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !gcRunning.TryLock() {
+			continue
+		}
+		report, err := cfg.collectGarbage(ctx, false)
+		gcRunning.Unlock()
+		if err != nil {
+			log.Printf("Garbage collection failed: %v", err)
+			continue
+		}
+		log.Printf("Garbage collection scanned %d objects, removed %d, expired %d uploads", report.Scanned, len(report.Removed), report.ExpiredUploads)
+	}
+}
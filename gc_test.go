@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestCollectGarbageKeepsIndexedContent(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.gcGracePeriod = 0
+	ctx := context.Background()
+
+	// An entry a job has acquired but not yet pointed its video at.
+	hlsKey := hlsPrefix + "shared/master.m3u8"
+	if _, _, err := cfg.db.RegisterContent(database.ContentObject{SHA256: "shared", VideoKey: "landscape/shared.mp4", HLSKey: &hlsKey}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"landscape/shared.mp4", hlsKey, hlsPrefix + "shared/720p/segment0.ts", "landscape/orphan.mp4"} {
+		if err := cfg.blobStore.Put(ctx, key, bytes.NewReader([]byte(key)), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := cfg.collectGarbage(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Key != "landscape/orphan.mp4" {
+		t.Errorf("removed %v, want only landscape/orphan.mp4", report.Removed)
+	}
+	for _, key := range []string{"landscape/shared.mp4", hlsKey, hlsPrefix + "shared/720p/segment0.ts"} {
+		if _, err := cfg.blobStore.Head(ctx, key); errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("indexed output %s was collected", key)
+		}
+	}
+}
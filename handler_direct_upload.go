@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Direct browser uploads: the client asks for a presigned POST policy, posts
+// the file straight to the bucket under incoming/<videoID>/, then calls the
+// completion endpoint so the server can pull the object down and queue it
+// like any other upload.
+
+const incomingPrefix = "incoming/"
+
+// postPresigner is implemented by stores that accept browser form uploads.
+type postPresigner interface {
+	PresignPost(ctx context.Context, policy storage.PostPolicy) (storage.PresignedPost, error)
+}
+
+func (cfg *apiConfig) handlerVideoUploadPresign(w http.ResponseWriter, r *http.Request) {
+	presigner, ok := cfg.blobStore.(postPresigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads need the s3 storage backend", nil)
+		return
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	contentType := "video/mp4"
+	if requested := r.URL.Query().Get("content_type"); requested != "" {
+		contentType = normalizeVideoType(requested)
+	}
+	format, ok := videoFormats[contentType]
+	if !ok {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported content_type", nil)
+		return
+	}
+
+	name := make([]byte, 16)
+	rand.Read(name)
+	prefix := incomingPrefix + video.ID.String() + "/"
+
+	post, err := presigner.PresignPost(r.Context(), storage.PostPolicy{
+		Key:         prefix + hex.EncodeToString(name) + format.extension,
+		KeyPrefix:   prefix,
+		ContentType: contentType,
+		MinSize:     1,
+		MaxSize:     maxVideoUploadSize,
+		Expires:     cfg.presignTTL,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, post)
+}
+
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	prefix := incomingPrefix + video.ID.String() + "/"
+	if !strings.HasPrefix(params.Key, prefix) || strings.Contains(params.Key, "..") {
+		respondWithError(w, http.StatusBadRequest, "Key does not belong to this video", nil)
+		return
+	}
+
+	info, err := cfg.blobStore.Head(r.Context(), params.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload", err)
+		return
+	}
+	// The POST policy already enforces these, but the object could have been
+	// written some other way.
+	if info.Size > maxVideoUploadSize {
+		cfg.deleteIncoming(params.Key)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+	if !isAcceptedVideoType(info.ContentType) {
+		cfg.deleteIncoming(params.Key)
+		respondWithError(w, http.StatusNotAcceptable, "Invalid Content-Type", nil)
+		return
+	}
+
+	inputPath := cfg.newJobInputPath()
+	inputSHA256, err := cfg.downloadObject(r.Context(), params.Key, inputPath)
+	if err != nil {
+		os.Remove(inputPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch upload", err)
+		return
+	}
+
+	_, err = validateVideoFile(inputPath, info.ContentType)
+	if err != nil {
+		os.Remove(inputPath)
+		if errors.Is(err, errUnsupportedMedia) {
+			cfg.deleteIncoming(params.Key)
+		}
+		respondWithValidationError(w, err)
+		return
+	}
+
+	job, err := cfg.enqueueVideoJob(video, inputPath, inputSHA256)
+	if err != nil {
+		os.Remove(inputPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	// The processed copy is stored under its own key; anything left here is
+	// picked up by the garbage collector.
+	cfg.deleteIncoming(params.Key)
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// downloadObject copies an object to filePath and returns its hex SHA-256.
+func (cfg *apiConfig) downloadObject(ctx context.Context, key, filePath string) (string, error) {
+	body, _, err := cfg.blobStore.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), io.LimitReader(body, maxVideoUploadSize))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (cfg *apiConfig) deleteIncoming(key string) {
+	if err := cfg.blobStore.Delete(context.Background(), key); err != nil {
+		log.Printf("Couldn't delete incoming upload %s: %v", key, err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func (cfg *apiConfig) handlerGarbageCollect(w http.ResponseWriter, r *http.Request) {
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return
+	}
+	if cfg.adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+		respondWithError(w, http.StatusForbidden, "Invalid API key", nil)
+		return
+	}
+
+	dryRun := false
+	if dryRunString := r.URL.Query().Get("dry_run"); dryRunString != "" {
+		dryRun, err = strconv.ParseBool(dryRunString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid dry_run value", err)
+			return
+		}
+	}
+
+	if !gcRunning.TryLock() {
+		respondWithError(w, http.StatusConflict, "Garbage collection already running", errors.New("gc already running"))
+		return
+	}
+	defer gcRunning.Unlock()
+
+	report, err := cfg.collectGarbage(r.Context(), dryRun)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Garbage collection failed", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
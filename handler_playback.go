@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type playbackResponse struct {
+	Video     database.Video    `json:"video"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Cookies   map[string]string `json:"cookies"`
+}
+
+// handlerPlayback hands out CloudFront signed cookies covering the video's
+// HLS and DASH output, so players can fetch segments from the edge without
+// signing each request. The MP4 lives outside those directories and is
+// reached through the signed video_url in the body instead. The cookie values
+// are also returned in the body for native players that set them by hand.
+func (cfg *apiConfig) handlerPlayback(w http.ResponseWriter, r *http.Request) {
+	if cfg.cfSigner == nil {
+		respondWithError(w, http.StatusNotImplemented, "CloudFront signing is not configured", nil)
+		return
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	expiresAt := time.Now().Add(cfg.presignTTL)
+	resource := cfg.urls.CDN("*/" + cfg.streamingOutputID(video) + "/*")
+	cookies, err := cfg.cfSigner.SignedCookies(cfsign.NewCustomPolicy(resource, expiresAt, time.Time{}, ""))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign playback cookies", err)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	values := map[string]string{}
+	for _, cookie := range cookies {
+		cookie.Domain = cfg.cfCookieDomain
+		cookie.Path = "/"
+		cookie.Expires = expiresAt
+		cookie.Secure = true
+		cookie.HttpOnly = true
+		cookie.SameSite = http.SameSiteNoneMode
+		http.SetCookie(w, cookie)
+		values[cookie.Name] = cookie.Value
+	}
+
+	respondWithJSON(w, http.StatusOK, playbackResponse{
+		Video:     video,
+		ExpiresAt: expiresAt,
+		Cookies:   values,
+	})
+}
+
+// streamingOutputID is the directory name the video's HLS and DASH output is
+// stored under. Outputs shared through the content index are named after the
+// upload that produced them; older ones after the video itself.
+func (cfg *apiConfig) streamingOutputID(video database.Video) string {
+	for _, manifestURL := range []*string{video.HLSURL, video.DASHURL} {
+		if manifestURL == nil {
+			continue
+		}
+		if key, ok := cfg.getObjectKey(*manifestURL); ok {
+			return path.Base(path.Dir(key))
+		}
+	}
+	return video.ID.String()
+}
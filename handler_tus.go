@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Resumable video uploads following the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload) with the creation, termination
+// and expiration extensions. Partial data lives under cfg.uploadsRoot until
+// the last chunk arrives, then the file is queued for processing like a
+// regular upload. The final PATCH reports the job in a Tus-Job-ID header.
+// Uploads that receive nothing for cfg.uploadExpiry are dropped.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+// uploadLocks serialises PATCH requests for the same upload.
+var uploadLocks sync.Map
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxVideoUploadSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	uploadLength, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || uploadLength <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if uploadLength > maxVideoUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	rawMetadata := r.Header.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(rawMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	videoID, err := uuid.Parse(metadata["video_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid video_id", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "User is not video owner", nil)
+		return
+	}
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID:  videoID,
+		UserID:   userID,
+		Length:   uploadLength,
+		Metadata: rawMetadata,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	file, err := os.Create(cfg.uploadPath(upload.ID))
+	if err != nil {
+		cfg.db.DeleteUpload(upload.ID)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	file.Close()
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", "/api/uploads/"+upload.ID.String())
+	cfg.setUploadExpires(w, upload.UpdatedAt)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	cfg.setUploadExpires(w, upload.UpdatedAt)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	lock, _ := uploadLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		respondWithError(w, http.StatusConflict, "Upload is already receiving data", nil)
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Re-read under the lock; a concurrent PATCH may have moved the offset,
+	// or the upload may have expired in the meantime.
+	upload, err := cfg.db.GetUpload(upload.ID)
+	if err != nil || upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset does not match", nil)
+		return
+	}
+
+	file, err := os.OpenFile(cfg.uploadPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek upload file", err)
+		return
+	}
+
+	// Whatever arrives before the connection drops is kept, that is the
+	// whole point of a resumable upload.
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, upload.Length-upload.Offset))
+	if err := file.Sync(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't flush upload file", err)
+		return
+	}
+	upload.Offset += written
+	if err := cfg.db.UpdateUploadOffset(upload.ID, upload.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload interrupted", copyErr)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+
+	if upload.Offset < upload.Length {
+		cfg.setUploadExpires(w, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video no longer exists", nil)
+		return
+	}
+
+	// A rejected file can't be fixed by resuming, so the upload is dropped.
+	if _, err := validateVideoFile(cfg.uploadPath(upload.ID), ""); err != nil {
+		if errors.Is(err, errUnsupportedMedia) {
+			cfg.removeUpload(upload.ID)
+		}
+		respondWithValidationError(w, err)
+		return
+	}
+
+	// The file arrived over several requests, so it's hashed in one pass here.
+	inputSHA256, err := hashFile(cfg.uploadPath(upload.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finalize upload", err)
+		return
+	}
+
+	inputPath := cfg.newJobInputPath()
+	if err := os.Rename(cfg.uploadPath(upload.ID), inputPath); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't finalize upload", err)
+		return
+	}
+
+	job, err := cfg.enqueueVideoJob(video, inputPath, inputSHA256)
+	if err != nil {
+		// Put the data back so a zero-length PATCH at the final offset can
+		// retry queueing.
+		os.Rename(inputPath, cfg.uploadPath(upload.ID))
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	cfg.removeUpload(upload.ID)
+	w.Header().Set("Tus-Job-ID", job.ID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.removeUpload(upload.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getOwnedUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.Upload{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Upload{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find upload", nil)
+		return database.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "User is not upload owner", nil)
+		return database.Upload{}, false
+	}
+	if cfg.uploadExpired(upload) && cfg.expireUpload(upload.ID) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) uploadExpired(upload database.Upload) bool {
+	return cfg.uploadExpiry > 0 && time.Since(upload.UpdatedAt) > cfg.uploadExpiry
+}
+
+// setUploadExpires tells the client when an upload last touched at
+// lastActivity will expire.
+func (cfg *apiConfig) setUploadExpires(w http.ResponseWriter, lastActivity time.Time) {
+	if cfg.uploadExpiry > 0 {
+		w.Header().Set("Upload-Expires", lastActivity.Add(cfg.uploadExpiry).UTC().Format(http.TimeFormat))
+	}
+}
+
+// expireUpload removes an upload unless a PATCH is writing to it right now,
+// and reports whether it did.
+func (cfg *apiConfig) expireUpload(uploadID uuid.UUID) bool {
+	lock, _ := uploadLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return false
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	if err := cfg.removeUpload(uploadID); err != nil {
+		log.Printf("Couldn't remove expired upload %s: %v", uploadID, err)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) uploadPath(uploadID uuid.UUID) string {
+	return filepath.Join(cfg.uploadsRoot, uploadID.String())
+}
+
+func (cfg *apiConfig) removeUpload(uploadID uuid.UUID) error {
+	err := os.Remove(cfg.uploadPath(uploadID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	uploadLocks.Delete(uploadID)
+	return cfg.db.DeleteUpload(uploadID)
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version", nil)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not valid base64: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"   ", map[string]string{}, false},
+		{"filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==", map[string]string{"filename": "world_domination_plan.pdf"}, false},
+		{"video_id YWJj,is_confidential", map[string]string{"video_id": "abc", "is_confidential": ""}, false},
+		{" a YQ== , b Yg== ", map[string]string{"a": "a", "b": "b"}, false},
+		{"filename not*base64", nil, true},
+		{",filename YQ==", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTusMetadata(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTusMetadata(%q) error = %v, want error %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTusMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func tusRequest(method, target, token string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	return authorize(r, token)
+}
+
+// createTusUpload starts an upload for videoID and returns the response.
+func createTusUpload(cfg *apiConfig, token string, videoID uuid.UUID, length int) *httptest.ResponseRecorder {
+	r := tusRequest(http.MethodPost, "/api/uploads/", token, "")
+	r.Header.Set("Upload-Length", strconv.Itoa(length))
+	r.Header.Set("Upload-Metadata", "video_id "+base64.StdEncoding.EncodeToString([]byte(videoID.String())))
+	w := httptest.NewRecorder()
+	cfg.handlerTusCreate(w, r)
+	return w
+}
+
+func patchTusUpload(cfg *apiConfig, token, uploadID, offset, body string) *httptest.ResponseRecorder {
+	r := tusRequest(http.MethodPatch, "/api/uploads/"+uploadID, token, body)
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", offset)
+	r.SetPathValue("uploadID", uploadID)
+	w := httptest.NewRecorder()
+	cfg.handlerTusPatch(w, r)
+	return w
+}
+
+func TestTusCreate(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+	video := newTestVideo(t, cfg, ownerID)
+
+	if w := createTusUpload(cfg, ownerToken, uuid.New(), 10); w.Code != http.StatusNotFound {
+		t.Errorf("creating an upload for a missing video = %d, want 404", w.Code)
+	}
+	if w := createTusUpload(cfg, otherToken, video.ID, 10); w.Code != http.StatusForbidden {
+		t.Errorf("creating an upload for someone else's video = %d, want 403", w.Code)
+	}
+	if w := createTusUpload(cfg, ownerToken, video.ID, maxVideoUploadSize+1); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("creating an oversized upload = %d, want 413", w.Code)
+	}
+
+	w := createTusUpload(cfg, ownerToken, video.ID, 10)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating an upload = %d: %s", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(w.Header().Get("Location"), "/api/uploads/") {
+		t.Errorf("Location = %q", w.Header().Get("Location"))
+	}
+	if w.Header().Get("Upload-Expires") == "" {
+		t.Error("no Upload-Expires header")
+	}
+}
+
+func TestTusPatchOffsets(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+	video := newTestVideo(t, cfg, ownerID)
+
+	created := createTusUpload(cfg, ownerToken, video.ID, 10)
+	if created.Code != http.StatusCreated {
+		t.Fatalf("creating an upload = %d: %s", created.Code, created.Body)
+	}
+	uploadID := path.Base(created.Header().Get("Location"))
+
+	steps := []struct {
+		name       string
+		token      string
+		offset     string
+		body       string
+		wantCode   int
+		wantOffset string
+	}{
+		{"first chunk", ownerToken, "0", "abcd", http.StatusNoContent, "4"},
+		{"stale offset", ownerToken, "0", "abcd", http.StatusConflict, ""},
+		{"offset ahead", ownerToken, "8", "ij", http.StatusConflict, ""},
+		{"bad offset", ownerToken, "four", "efgh", http.StatusBadRequest, ""},
+		{"not the owner", otherToken, "4", "efgh", http.StatusForbidden, ""},
+		{"second chunk", ownerToken, "4", "efgh", http.StatusNoContent, "8"},
+		{"empty chunk", ownerToken, "8", "", http.StatusNoContent, "8"},
+	}
+	for _, step := range steps {
+		w := patchTusUpload(cfg, step.token, uploadID, step.offset, step.body)
+		if w.Code != step.wantCode {
+			t.Errorf("%s: PATCH = %d, want %d: %s", step.name, w.Code, step.wantCode, w.Body)
+			continue
+		}
+		if step.wantOffset != "" && w.Header().Get("Upload-Offset") != step.wantOffset {
+			t.Errorf("%s: Upload-Offset = %q, want %q", step.name, w.Header().Get("Upload-Offset"), step.wantOffset)
+		}
+	}
+
+	data, err := os.ReadFile(cfg.uploadPath(uuid.MustParse(uploadID)))
+	if err != nil {
+		t.Fatalf("reading upload file: %v", err)
+	}
+	if string(data) != "abcdefgh" {
+		t.Errorf("upload file = %q, want %q", data, "abcdefgh")
+	}
+
+	r := tusRequest(http.MethodPatch, "/api/uploads/"+uploadID, ownerToken, "ij")
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Header.Set("Upload-Offset", "8")
+	r.SetPathValue("uploadID", uploadID)
+	w := httptest.NewRecorder()
+	cfg.handlerTusPatch(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH with the wrong Content-Type = %d, want 415", w.Code)
+	}
+
+	r = tusRequest(http.MethodHead, "/api/uploads/"+uploadID, ownerToken, "")
+	r.SetPathValue("uploadID", uploadID)
+	w = httptest.NewRecorder()
+	cfg.handlerTusHead(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "8" || w.Header().Get("Upload-Length") != "10" {
+		t.Errorf("HEAD = %d with offset %q and length %q", w.Code, w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"))
+	}
+}
+
+func TestTusPatchConcurrent(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, ownerID)
+
+	created := createTusUpload(cfg, ownerToken, video.ID, 10)
+	uploadID := path.Base(created.Header().Get("Location"))
+
+	// Hold the lock as a PATCH in progress would.
+	lock, _ := uploadLocks.LoadOrStore(uuid.MustParse(uploadID), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	w := patchTusUpload(cfg, ownerToken, uploadID, "0", "abcd")
+	lock.(*sync.Mutex).Unlock()
+	if w.Code != http.StatusConflict {
+		t.Errorf("PATCH while another is running = %d, want 409", w.Code)
+	}
+}
+
+func TestTusExpiredUpload(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, ownerID)
+
+	created := createTusUpload(cfg, ownerToken, video.ID, 10)
+	uploadID := path.Base(created.Header().Get("Location"))
+
+	cfg.uploadExpiry = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if w := patchTusUpload(cfg, ownerToken, uploadID, "0", "abcd"); w.Code != http.StatusGone {
+		t.Errorf("PATCH to an expired upload = %d, want 410", w.Code)
+	}
+	if _, err := os.Stat(cfg.uploadPath(uuid.MustParse(uploadID))); !os.IsNotExist(err) {
+		t.Errorf("expired upload file still exists: %v", err)
+	}
+	if w := patchTusUpload(cfg, ownerToken, uploadID, "0", "abcd"); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH after expiry = %d, want 404", w.Code)
+	}
+}
+
+func TestCollectUploads(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	video := newTestVideo(t, cfg, ownerID)
+
+	live := path.Base(createTusUpload(cfg, ownerToken, video.ID, 10).Header().Get("Location"))
+
+	queuedInput := cfg.newJobInputPath()
+	writeOldFile(t, queuedInput)
+	if _, err := cfg.enqueueVideoJob(video, queuedInput, ""); err != nil {
+		t.Fatalf("queueing job: %v", err)
+	}
+	orphan := cfg.newJobInputPath()
+	writeOldFile(t, orphan)
+	scratch, err := os.MkdirTemp(cfg.uploadsRoot, "hls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recentScratch, err := os.MkdirTemp(cfg.uploadsRoot, "dash-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, p := range []string{cfg.uploadPath(uuid.MustParse(live)), scratch} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := gcReport{StartedAt: time.Now().UTC(), RemovedUploadFiles: []string{}}
+	if err := cfg.collectUploads(&report, report.StartedAt.Add(-cfg.gcGracePeriod)); err != nil {
+		t.Fatalf("collectUploads: %v", err)
+	}
+	if report.ExpiredUploads != 0 {
+		t.Errorf("expired %d uploads, want none", report.ExpiredUploads)
+	}
+	removed := map[string]bool{}
+	for _, name := range report.RemovedUploadFiles {
+		removed[name] = true
+	}
+	want := map[string]bool{path.Base(orphan): true, path.Base(scratch): true}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", report.RemovedUploadFiles, want)
+	}
+	for _, kept := range []string{cfg.uploadPath(uuid.MustParse(live)), queuedInput, recentScratch} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("%s was removed: %v", kept, err)
+		}
+	}
+
+	cfg.uploadExpiry = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	report = gcReport{StartedAt: time.Now().UTC().Add(time.Second), RemovedUploadFiles: []string{}}
+	if err := cfg.collectUploads(&report, report.StartedAt.Add(-cfg.gcGracePeriod)); err != nil {
+		t.Fatalf("collectUploads: %v", err)
+	}
+	if report.ExpiredUploads != 1 {
+		t.Errorf("expired %d uploads, want 1", report.ExpiredUploads)
+	}
+	if upload, err := cfg.db.GetUpload(uuid.MustParse(live)); err != nil || upload.ID != uuid.Nil {
+		t.Errorf("expired upload still recorded: %+v, %v", upload, err)
+	}
+}
+
+func writeOldFile(t *testing.T, name string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,14 +1,9 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
@@ -35,9 +30,12 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	digest, err := newUploadDigest(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid digest header", err)
+		return
+	}
 
-	// TODO: implement the upload here
 	err = r.ParseMultipartForm(maxMemory)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not parse submission", err)
@@ -52,58 +50,32 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	defer file.Close()
 
 	contentType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil || (contentType != "image/jpeg" && contentType != "image/png") {
-		respondWithError(w, http.StatusInternalServerError, "Invalid Content-Type", err)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
 
-	//		contentType := header.Header.Get("Content-Type")
-	var fileExtension string
-
-	switch contentType {
-	case "text/html":
-		fileExtension = ".html"
-	case "image/jpeg":
-		fileExtension = ".jpeg"
-	case "image/png":
-		fileExtension = ".png"
-	case "application/pdf":
-		fileExtension = ".pdf"
-	case "application/json":
-		fileExtension = ".json"
-	case "video/mp4":
-		fileExtension = ".mp4"
-	case "audio/mp3":
-		fileExtension = ".mp3"
-	default:
-		fileExtension = ".txt"
-	}
-
-	name := make([]byte, 32)
-	rand.Read(name)
-	fileName := base64.RawURLEncoding.EncodeToString(name)
-
-	filePath := filepath.Join(cfg.assetsRoot, fileName+fileExtension)
-
-	newFile, err := os.Create(filePath)
+	data, err := io.ReadAll(io.LimitReader(file, maxMemory+1))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not create file", err)
+		respondWithError(w, http.StatusInternalServerError, "Error reading file", err)
 		return
 	}
-	defer newFile.Close()
-	/*
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error reading file into buffer", err)
-			return
-		}
-	*/
-	if _, err := io.Copy(newFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not write to file", err)
+	if len(data) > maxMemory {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", nil)
 		return
 	}
 
-	//	file64 := base64.StdEncoding.EncodeToString(fileBytes)
+	digest.Write(data)
+	if err := digest.Verify(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload doesn't match its digest", err)
+		return
+	}
+
+	_, err = validateThumbnail(data, contentType)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
 
 	videoData, err := cfg.db.GetVideo(videoID)
 	if err != nil {
@@ -120,16 +92,35 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		}
 	*/
 
-	videoURL := fmt.Sprintf("http://localhost:%s/%s", cfg.port, filePath)
-	videoData.ThumbnailURL = &videoURL
+	variants, err := cfg.storeThumbnailVariants(r.Context(), videoID, data)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not process thumbnail", err)
+		return
+	}
+	largest, ok := largestJPEG(variants)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Could not process thumbnail", nil)
+		return
+	}
 
-	err = cfg.db.UpdateVideo(videoData)
+	err = cfg.db.SetVideoThumbnail(videoID, largest.URL, variants)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Could not update video metadata", err)
 		return
 	}
+	videoData, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving video metadata", err)
+		return
+	}
 
 	//videoThumbnails[videoID] = thumbnail
 
+	videoData, err = cfg.dbVideoToSignedVideo(r.Context(), videoData)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, videoData)
 }
@@ -2,29 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const maxMemory = 1 << 30
+const maxVideoUploadSize = 1 << 30
 
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxMemory)
+	r.Body = http.MaxBytesReader(w, r.Body, maxVideoUploadSize)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -54,6 +56,12 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+
+	digest, err := newUploadDigest(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid digest header", err)
+		return
+	}
 	file, header, err := r.FormFile("video")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error retrieving file", err)
@@ -62,34 +70,61 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer file.Close()
 
 	contentType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
-	if err != nil || (contentType != "video/mp4") {
+	if err != nil || !isAcceptedVideoType(contentType) {
 		respondWithError(w, http.StatusNotAcceptable, "Invalid Content-Type", err)
 		return
 	}
 
-	videoFile, err := os.CreateTemp("", "tubely-upload.mp4")
-
+	inputPath := cfg.newJobInputPath()
+	videoFile, err := os.Create(inputPath)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Error creating file", err)
 		return
 	}
-
-	defer os.Remove(videoFile.Name())
 	defer videoFile.Close()
 
-	_, err = io.Copy(videoFile, file)
+	_, err = io.Copy(io.MultiWriter(videoFile, digest), file)
 	if err != nil {
+		os.Remove(inputPath)
 		respondWithError(w, http.StatusInternalServerError, "Could not copy file", err)
 		return
 	}
 
-	_, err = videoFile.Seek(0, io.SeekStart)
+	if err := digest.Verify(); err != nil {
+		os.Remove(inputPath)
+		respondWithError(w, http.StatusBadRequest, "Upload doesn't match its digest", err)
+		return
+	}
+
+	_, err = validateVideoFile(inputPath, contentType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not set file pointer", err)
+		os.Remove(inputPath)
+		respondWithValidationError(w, err)
 		return
 	}
 
-	aspectRatio, err := getVideoAspectRatio(videoFile.Name())
+	job, err := cfg.enqueueVideoJob(videoMetaData, inputPath, digest.SHA256())
+	if err != nil {
+		os.Remove(inputPath)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// processVideoUpload runs a fully received upload through ffprobe, the
+// MP4 normalisation, the HLS ladder and (if enabled) DASH, stores the results,
+// extracts thumbnail candidates and points the video row at all of it. When
+// the same bytes were processed before, the stored outputs are reused through
+// the content index instead.
+func (cfg *apiConfig) processVideoUpload(ctx context.Context, video database.Video, filePath, inputSHA256 string) (database.Video, error) {
+	probe, err := probeVideo(filePath)
+	if err != nil {
+		return video, fmt.Errorf("couldn't probe video: %w", err)
+	}
+
+	aspectRatio := getAspectRatio(probe.displaySize())
 
 	var aspectRatioString string
 
@@ -101,92 +136,166 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		aspectRatioString = "other/"
 	}
 
-	newFilePath, err := processVideoForFastStart(videoFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could process file", err)
-		return
+	if cfg.keepOriginals {
+		err = cfg.storeOriginal(ctx, video.ID, filePath)
+		if err != nil {
+			return video, fmt.Errorf("couldn't keep original upload: %w", err)
+		}
 	}
 
-	newFile, err := os.Open(newFilePath)
+	content, err := cfg.db.AcquireContent(inputSHA256)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could process file", err)
-		return
+		return video, fmt.Errorf("couldn't look up content index: %w", err)
 	}
-	defer os.Remove(newFilePath)
-	defer newFile.Close()
-
-	name := make([]byte, 32)
-	rand.Read(name)
-	fileName := aspectRatioString + hex.EncodeToString(name) + ".mp4"
-
-	params := &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &fileName,
-		Body:        newFile,
-		ContentType: &contentType,
+	if content.SHA256 == "" {
+		content, err = cfg.processVideoContent(ctx, filePath, inputSHA256, aspectRatioString, probe)
+		if err != nil {
+			return video, err
+		}
 	}
 
-	_, err = cfg.s3Client.PutObject(r.Context(), params)
+	video.VideoURL = &content.VideoKey
+	video.VideoSHA256 = content.VideoSHA256
+	video.HLSURL = content.HLSKey
+	video.DASHURL = content.DASHKey
+	video.ContentSHA256 = &content.SHA256
+	video.Metadata = probe.metadata(content.FileSize)
+
+	// A missing thumbnail shouldn't fail an otherwise playable video. Frames
+	// come from the upload itself since a reused entry has no local MP4.
+	err = cfg.generateThumbnailCandidates(ctx, &video, filePath, probe)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file", err)
-		return
+		log.Printf("Couldn't generate thumbnails for video %s: %v", video.ID, err)
 	}
 
-	videoURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, fileName)
-
-	videoMetaData.VideoURL = &videoURL
+	// Past the job timeout the garbage collector may already have taken
+	// outputs uploaded at the start.
+	if err := ctx.Err(); err != nil {
+		cfg.releaseContent(content.SHA256, video)
+		return video, err
+	}
 
-	err = cfg.db.UpdateVideo(videoMetaData)
+	// A re-upload replaces the outputs the video pointed at before, which
+	// are released with the swap.
+	pending, err := cfg.db.ReplaceVideoOutputs(video, cfg.videoContentRefs)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not update video metadata", err)
-		return
+		cfg.releaseContent(content.SHA256, video)
+		return video, fmt.Errorf("couldn't update video metadata: %w", err)
+	}
+	if len(pending) > 0 {
+		cfg.notifyPendingDeletions()
 	}
 
-	respondWithJSON(w, http.StatusOK, videoMetaData)
+	return video, nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-
-	var outBuff bytes.Buffer
+// processVideoContent produces and stores the outputs for bytes nobody has
+// uploaded before and registers them in the content index, returning the
+// entry the caller now holds a reference on.
+func (cfg *apiConfig) processVideoContent(ctx context.Context, filePath, inputSHA256, prefix string, probe videoProbe) (database.ContentObject, error) {
+	newFilePath, err := processVideoForFastStart(ctx, filePath, probe)
+	if err != nil {
+		return database.ContentObject{}, err
+	}
+	defer os.Remove(newFilePath)
 
-	videoData := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
+	videoSHA256, err := hashFile(newFilePath)
+	if err != nil {
+		return database.ContentObject{}, err
+	}
+	checksum, err := putChecksum(videoSHA256)
+	if err != nil {
+		return database.ContentObject{}, err
+	}
 
-	videoData.Stdout = &outBuff
+	newFile, err := os.Open(newFilePath)
+	if err != nil {
+		return database.ContentObject{}, err
+	}
+	defer newFile.Close()
 
-	err := videoData.Run()
+	stat, err := newFile.Stat()
 	if err != nil {
-		return "", err
+		return database.ContentObject{}, err
 	}
 
-	type Stream struct {
-		CodecType string `json:"codec_type"`
-		Width     int    `json:"width"`
-		Height    int    `json:"height"`
+	name := make([]byte, 32)
+	rand.Read(name)
+	fileName := prefix + hex.EncodeToString(name) + ".mp4"
+
+	err = cfg.blobStore.Put(ctx, fileName, newFile, storage.PutOptions{
+		ContentType:    "video/mp4",
+		Size:           stat.Size(),
+		ChecksumSHA256: checksum,
+	})
+	if err != nil {
+		return database.ContentObject{}, fmt.Errorf("couldn't upload video: %w", err)
 	}
 
-	type ProbeResult struct {
-		Streams []Stream `json:"streams"`
+	content := database.ContentObject{
+		SHA256:      inputSHA256,
+		VideoKey:    fileName,
+		VideoSHA256: &videoSHA256,
+		FileSize:    stat.Size(),
 	}
 
-	var results ProbeResult
-	err = json.Unmarshal(outBuff.Bytes(), &results)
+	// Streaming output is shared between videos, so its directory is named
+	// after the content rather than any one video. HLS and DASH use the same
+	// name so one playback cookie covers both.
+	outputID := uuid.New()
+	hlsKey, err := cfg.processVideoForHLS(ctx, outputID, newFilePath, probe)
 	if err != nil {
-		return "", err
+		return database.ContentObject{}, fmt.Errorf("couldn't build HLS ladder: %w", err)
 	}
+	content.HLSKey = &hlsKey
 
-	height := 0
-	width := 0
+	if cfg.dashEnabled {
+		dashKey, err := cfg.processVideoForDASH(ctx, outputID, newFilePath, probe)
+		if err != nil {
+			return database.ContentObject{}, fmt.Errorf("couldn't build DASH manifest: %w", err)
+		}
+		content.DASHKey = &dashKey
+	}
 
-	for _, result := range results.Streams {
-		if result.CodecType == "video" {
-			height = result.Height
-			width = result.Width
+	registered, created, err := cfg.db.RegisterContent(content)
+	if err != nil {
+		return database.ContentObject{}, fmt.Errorf("couldn't register content: %w", err)
+	}
+	if !created {
+		// An upload of the same bytes finished first; its copy wins and ours
+		// is dropped.
+		for _, ref := range cfg.videoContentRefs(contentVideo(content)) {
+			if err := cfg.deleteBlob(ctx, ref); err != nil {
+				log.Printf("Couldn't delete duplicate output %s: %v", ref.Key, err)
+			}
 		}
 	}
-	if height == 0 || width == 0 {
-		return "", errors.New("No valid videos found")
+	return registered, nil
+}
+
+// releaseContent drops the reference video held on the content entry and
+// deletes the outputs if nothing else uses them.
+func (cfg *apiConfig) releaseContent(sha256 string, video database.Video) {
+	pending, err := cfg.db.ReleaseContent(sha256, cfg.videoContentRefs(video))
+	if err != nil {
+		log.Printf("Couldn't release content %s: %v", sha256, err)
+		return
+	}
+	if len(pending) > 0 {
+		cfg.notifyPendingDeletions()
 	}
+}
 
+// contentVideo is a video pointing at nothing but the outputs of content.
+func contentVideo(content database.ContentObject) database.Video {
+	return database.Video{
+		VideoURL: &content.VideoKey,
+		HLSURL:   content.HLSKey,
+		DASHURL:  content.DASHKey,
+	}
+}
+
+func getAspectRatio(width, height int) string {
 	ratio := float64(width) / float64(height)
 
 	landscapeTarget := 16.0 / 9.0
@@ -194,20 +303,37 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	epsilon := 0.01
 
 	if math.Abs(ratio-landscapeTarget) < epsilon {
-		return "16:9", nil
+		return "16:9"
 	} else if math.Abs(ratio-portraitTarget) < epsilon {
-		return "9:16", nil
+		return "9:16"
 	} else {
-		return "other", nil
+		return "other"
 	}
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+// processVideoForFastStart produces an H.264/AAC MP4 with the moov atom up
+// front. Streams already in those codecs are copied as they are; anything else
+// (HEVC from iPhones, VP8/VP9/AV1 and Opus/Vorbis from WebM and MKV) is
+// transcoded.
+func processVideoForFastStart(ctx context.Context, filePath string, probe videoProbe) (string, error) {
 	var outputFilePath string
 
 	outputFilePath = filePath + ".processing"
 
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputFilePath)
+	args := []string{"-i", filePath, "-map", "0:v:0", "-map", "0:a:0?"}
+	if probe.VideoCodec == "h264" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p")
+	}
+	if probe.AudioCodec == "aac" || !probe.HasAudio {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "128k")
+	}
+	args = append(args, "-movflags", "faststart", "-f", "mp4", outputFilePath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	err := cmd.Run()
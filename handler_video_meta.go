@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -72,11 +77,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	_, err = cfg.db.DeleteVideoWithBlobs(videoID, cfg.videoOwnRefs(video), cfg.videoContentRefs(video))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	// The blobs are deleted in the background so the request doesn't wait on
+	// the store.
+	cfg.notifyPendingDeletions()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -89,9 +97,36 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find video", nil)
+		return
+	}
+	// The response carries signed playback URLs, so only those allowed to
+	// watch the video get it.
+	if video.UserID != userID && !video.Public {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
 		return
 	}
 
@@ -110,11 +145,132 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Cursor doesn't match this listing", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	for i, video := range page.Videos {
+		page.Videos[i], err = cfg.dbVideoToSignedVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	}
+
+	resp := videoListResponse{Videos: page.Videos}
+	if page.Next != nil {
+		next := page.Next.Encode()
+		resp.NextCursor = &next
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+const (
+	defaultVideoPageSize = 20
+	maxVideoPageSize     = 100
+)
+
+type videoListResponse struct {
+	Videos     []database.Video `json:"videos"`
+	NextCursor *string          `json:"next_cursor"`
+}
+
+// parseListVideosParams reads the listing options from the query string:
+// limit, cursor, sort (created_at, updated_at, title, duration), order (asc,
+// desc), has_video, has_thumbnail, orientation (landscape, portrait, square)
+// and the created_after/created_before range as RFC 3339 times. Titles sort
+// A to Z by default, everything else newest or longest first.
+func parseListVideosParams(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		Limit: defaultVideoPageSize,
+		Sort:  database.VideoSortCreatedAt,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideoPageSize)
+		}
+		params.Limit = n
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		params.Sort = database.VideoSort(sort)
+		switch params.Sort {
+		case database.VideoSortCreatedAt, database.VideoSortUpdatedAt, database.VideoSortTitle, database.VideoSortDuration:
+		default:
+			return params, fmt.Errorf("unknown sort %q", sort)
+		}
+	}
+	switch query.Get("order") {
+	case "":
+		params.Descending = params.Sort != database.VideoSortTitle
+	case "asc":
+	case "desc":
+		params.Descending = true
+	default:
+		return params, errors.New("order must be asc or desc")
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := database.DecodeVideoCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.After = &after
+	}
+
+	for name, filter := range map[string]**bool{
+		"has_video":     &params.HasVideo,
+		"has_thumbnail": &params.HasThumbnail,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, fmt.Errorf("%s must be true or false", name)
+		}
+		*filter = &b
+	}
+
+	if orientation := query.Get("orientation"); orientation != "" {
+		params.Orientation = database.Orientation(orientation)
+		switch params.Orientation {
+		case database.OrientationLandscape, database.OrientationPortrait, database.OrientationSquare:
+		default:
+			return params, fmt.Errorf("unknown orientation %q", orientation)
+		}
+	}
+
+	for name, bound := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return params, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*bound = &t
+	}
+
+	return params, nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func TestHandlerVideoGet(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, ownerToken := newTestUser(t, cfg, "owner@example.com")
+	_, otherToken := newTestUser(t, cfg, "other@example.com")
+
+	videoKey := "landscape/private.mp4"
+	if err := cfg.blobStore.Put(context.Background(), videoKey, strings.NewReader("video"), storage.PutOptions{ContentType: "video/mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	private := newTestVideo(t, cfg, ownerID)
+	private.VideoURL = &videoKey
+	if err := cfg.db.UpdateVideo(private); err != nil {
+		t.Fatal(err)
+	}
+	public, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Public video", Public: true, UserID: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		videoID string
+		token   string
+		want    int
+	}{
+		{"owner", private.ID.String(), ownerToken, http.StatusOK},
+		{"someone else, private", private.ID.String(), otherToken, http.StatusForbidden},
+		{"someone else, public", public.ID.String(), otherToken, http.StatusOK},
+		{"no token", private.ID.String(), "", http.StatusUnauthorized},
+		{"bad token", public.ID.String(), "not-a-jwt", http.StatusUnauthorized},
+		{"missing", uuid.NewString(), ownerToken, http.StatusNotFound},
+		{"bad ID", "not-a-uuid", ownerToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/videos/"+tt.videoID, nil)
+		r.SetPathValue("videoID", tt.videoID)
+		if tt.token != "" {
+			authorize(r, tt.token)
+		}
+		w := httptest.NewRecorder()
+		cfg.handlerVideoGet(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			if strings.Contains(w.Body.String(), videoKey) {
+				t.Errorf("%s: refused response leaks the video URL: %s", tt.name, w.Body)
+			}
+			continue
+		}
+		var video database.Video
+		if err := json.Unmarshal(w.Body.Bytes(), &video); err != nil {
+			t.Fatal(err)
+		}
+		if video.ID.String() != tt.videoID {
+			t.Errorf("%s: got video %s", tt.name, video.ID)
+		}
+	}
+}
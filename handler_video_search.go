@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params, err := parseSearchVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+
+	page, err := cfg.db.SearchVideos(params)
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithError(w, http.StatusBadRequest, "Search needs at least one word", err)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Cursor doesn't match this search", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	resp := videoSearchResponse{Results: []videoSearchResult{}}
+	for _, hit := range page.Hits {
+		video, err := cfg.dbVideoToSignedVideo(r.Context(), hit.Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+		resp.Results = append(resp.Results, videoSearchResult{
+			Video:                video,
+			TitleHighlight:       highlightHTML(hit.TitleHighlight),
+			DescriptionHighlight: highlightHTML(hit.DescriptionHighlight),
+		})
+	}
+	if page.Next != nil {
+		next := page.Next.Encode()
+		resp.NextCursor = &next
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+type videoSearchResult struct {
+	Video                database.Video `json:"video"`
+	TitleHighlight       string         `json:"title_highlight"`
+	DescriptionHighlight string         `json:"description_highlight"`
+}
+
+type videoSearchResponse struct {
+	Results    []videoSearchResult `json:"results"`
+	NextCursor *string             `json:"next_cursor"`
+}
+
+// parseSearchVideosParams reads q, the words to search for, scope (own, the
+// default, or public), and limit and cursor as for listing videos.
+func parseSearchVideosParams(query url.Values) (database.SearchVideosParams, error) {
+	params := database.SearchVideosParams{
+		Query: query.Get("q"),
+		Scope: database.SearchScopeOwn,
+		Limit: defaultVideoPageSize,
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		return params, errors.New("q is required")
+	}
+
+	if scope := query.Get("scope"); scope != "" {
+		params.Scope = database.SearchScope(scope)
+		switch params.Scope {
+		case database.SearchScopeOwn, database.SearchScopePublic:
+		default:
+			return params, fmt.Errorf("unknown scope %q, expected own or public", scope)
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideoPageSize)
+		}
+		params.Limit = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := database.DecodeVideoCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.After = &after
+	}
+	return params, nil
+}
+
+// highlightHTML escapes a search highlight for use as HTML, with the matched
+// words wrapped in <mark>.
+func highlightHTML(highlight string) string {
+	return strings.NewReplacer(
+		database.SearchMatchStart, "<mark>",
+		database.SearchMatchEnd, "</mark>",
+	).Replace(html.EscapeString(highlight))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/urlbuilder"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestConfig returns a server config backed by a fresh SQLite database,
+// in-memory blob storage and temporary directories.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	urls, err := urlbuilder.New(urlbuilder.Config{
+		PublicBaseURL: "http://localhost:8091",
+		Bucket:        "tubely-test",
+		Region:        "us-east-2",
+	})
+	if err != nil {
+		t.Fatalf("building URLs: %v", err)
+	}
+
+	uploadsRoot := filepath.Join(dir, "uploads")
+	assetsRoot := filepath.Join(dir, "assets")
+	cfg := &apiConfig{
+		db:             db,
+		jwtSecret:      testJWTSecret,
+		platform:       "dev",
+		assetsRoot:     assetsRoot,
+		uploadsRoot:    uploadsRoot,
+		storageBackend: "memory",
+		blobStore:      storage.NewMemoryStore(),
+		assetStore:     storage.NewLocalStore(assetsRoot, urls.AssetBase()),
+		gcGracePeriod:  time.Hour,
+		jobTimeout:     time.Hour,
+		uploadExpiry:   24 * time.Hour,
+		jobNotify:      make(chan struct{}, 1),
+		deletionNotify: make(chan struct{}, 1),
+		presignTTL:     time.Minute,
+		urls:           urls,
+	}
+	if err := cfg.ensureAssetsDir(); err != nil {
+		t.Fatalf("creating assets directory: %v", err)
+	}
+	if err := os.MkdirAll(uploadsRoot, 0755); err != nil {
+		t.Fatalf("creating uploads directory: %v", err)
+	}
+	return cfg
+}
+
+// newTestUser creates a user and returns its ID and an access token.
+func newTestUser(t *testing.T, cfg *apiConfig, email string) (uuid.UUID, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "unused"})
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, testJWTSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("making JWT: %v", err)
+	}
+	return user.ID, token
+}
+
+func newTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Test video", UserID: userID})
+	if err != nil {
+		t.Fatalf("creating video: %v", err)
+	}
+	return video
+}
+
+func authorize(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const hlsPrefix = "hls/"
+
+type rendition struct {
+	// Height is the short side of the output, so a 720 rendition of a
+	// portrait video is 720 pixels wide.
+	Height       int
+	VideoBitrate int
+	AudioBitrate int
+}
+
+var defaultLadder = []rendition{
+	{Height: 1080, VideoBitrate: 5000_000, AudioBitrate: 128_000},
+	{Height: 720, VideoBitrate: 2800_000, AudioBitrate: 128_000},
+	{Height: 480, VideoBitrate: 1400_000, AudioBitrate: 96_000},
+	{Height: 360, VideoBitrate: 800_000, AudioBitrate: 96_000},
+}
+
+// parseLadder reads a comma separated list of rendition heights, picking
+// bitrates from the default ladder or scaling them for unknown heights.
+func parseLadder(value string) ([]rendition, error) {
+	if value == "" {
+		return defaultLadder, nil
+	}
+	ladder := []rendition{}
+	for _, field := range strings.Split(value, ",") {
+		height, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || height <= 0 {
+			return nil, fmt.Errorf("invalid rendition height %q", field)
+		}
+		ladder = append(ladder, renditionForHeight(height))
+	}
+	return ladder, nil
+}
+
+func renditionForHeight(height int) rendition {
+	for _, r := range defaultLadder {
+		if r.Height == height {
+			return r
+		}
+	}
+	// Roughly 0.1 bits per pixel at 30fps for a 16:9 frame.
+	return rendition{
+		Height:       height,
+		VideoBitrate: height * height * 16 / 9 * 3,
+		AudioBitrate: 128_000,
+	}
+}
+
+// ladderFor drops renditions larger than the source. A source smaller than
+// every rung still gets one rendition at its own size, rounded down to even
+// but never below 2, the smallest frame libx264 encodes.
+func ladderFor(ladder []rendition, probe videoProbe) []rendition {
+	shortSide := min(probe.Width, probe.Height)
+	renditions := []rendition{}
+	for _, r := range ladder {
+		if r.Height <= shortSide {
+			renditions = append(renditions, r)
+		}
+	}
+	if len(renditions) == 0 {
+		r := renditionForHeight(max(shortSide-shortSide%2, 2))
+		renditions = append(renditions, r)
+	}
+	return renditions
+}
+
+func (r rendition) name() string {
+	return fmt.Sprintf("%dp", r.Height)
+}
+
+// size returns the output dimensions for a source, keeping the aspect ratio
+// and rounding to even numbers as libx264 requires.
+func (r rendition) size(probe videoProbe) (int, int) {
+	even := func(n float64) int {
+		return int(n/2+0.5) * 2
+	}
+	// ffmpeg applies the rotation while transcoding, so size the output
+	// from the displayed frame rather than the stored one.
+	width, height := probe.displaySize()
+	if width >= height {
+		return even(float64(width) * float64(r.Height) / float64(height)), r.Height
+	}
+	return r.Height, even(float64(height) * float64(r.Height) / float64(width))
+}
+
+func (r rendition) bandwidth(probe videoProbe) int {
+	if probe.HasAudio {
+		return r.VideoBitrate + r.AudioBitrate
+	}
+	return r.VideoBitrate
+}
+
+// processVideoForHLS transcodes the video into the configured ladder, writes
+// a master playlist and uploads everything under hls/<outputID>/. It returns
+// the key of the master playlist.
+func (cfg *apiConfig) processVideoForHLS(ctx context.Context, outputID uuid.UUID, filePath string, probe videoProbe) (string, error) {
+	outputDir, err := os.MkdirTemp(cfg.uploadsRoot, "hls-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outputDir)
+
+	renditions := ladderFor(cfg.hlsLadder, probe)
+	for _, r := range renditions {
+		if err := transcodeHLSRendition(ctx, filePath, outputDir, r, probe); err != nil {
+			return "", err
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(outputDir, "master.m3u8"), buildMasterPlaylist(renditions, probe), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := hlsPrefix + outputID.String() + "/"
+	err = cfg.putDirectory(ctx, outputDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "master.m3u8", nil
+}
+
+func transcodeHLSRendition(ctx context.Context, filePath, outputDir string, r rendition, probe videoProbe) error {
+	renditionDir := filepath.Join(outputDir, r.name())
+	if err := os.Mkdir(renditionDir, 0755); err != nil {
+		return err
+	}
+
+	width, height := r.size(probe)
+	args := []string{
+		"-i", filePath,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-b:v", strconv.Itoa(r.VideoBitrate),
+		"-maxrate", strconv.Itoa(r.VideoBitrate * 107 / 100),
+		"-bufsize", strconv.Itoa(r.VideoBitrate * 3 / 2),
+		// Fixed two second GOPs so every segment starts on a keyframe.
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+	}
+	if probe.HasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-b:a", strconv.Itoa(r.AudioBitrate),
+			"-ac", "2",
+		)
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(renditionDir, "segment_%04d.ts"),
+		filepath.Join(renditionDir, "index.m3u8"),
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg %s rendition failed with error: %v\n and stderr: %s", r.name(), err, stderr.String())
+	}
+	return nil
+}
+
+func buildMasterPlaylist(renditions []rendition, probe videoProbe) []byte {
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range renditions {
+		width, height := r.size(probe)
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n", r.bandwidth(probe), width, height)
+		fmt.Fprintf(&playlist, "%s/index.m3u8\n", r.name())
+	}
+	return playlist.Bytes()
+}
+
+var streamingContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+}
+
+// putDirectory uploads every file below dir, keyed by prefix plus its path
+// relative to dir.
+func (cfg *apiConfig) putDirectory(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		checksum, err := fileChecksum(filePath)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+		err = cfg.blobStore.Put(ctx, key, file, storage.PutOptions{
+			ContentType:    streamingContentTypes[path.Ext(key)],
+			Size:           stat.Size(),
+			ChecksumSHA256: checksum,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't upload %s: %w", key, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLadderFor(t *testing.T) {
+	heights := func(renditions []rendition) []int {
+		out := []int{}
+		for _, r := range renditions {
+			out = append(out, r.Height)
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		want   []int
+	}{
+		{"1080p landscape", 1920, 1080, []int{1080, 720, 480, 360}},
+		{"720p portrait", 720, 1280, []int{720, 480, 360}},
+		{"between rungs", 1000, 600, []int{480, 360}},
+		{"below every rung", 320, 240, []int{240}},
+		{"odd short side", 321, 241, []int{240}},
+		{"two pixels", 2, 100, []int{2}},
+		{"one pixel", 1, 100, []int{2}},
+	}
+	for _, tt := range tests {
+		got := heights(ladderFor(defaultLadder, videoProbe{Width: tt.width, Height: tt.height}))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ladderFor(%dx%d) = %v, want %v", tt.name, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestParseLadder(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []rendition
+		wantErr bool
+	}{
+		{"", defaultLadder, false},
+		{"720, 360", []rendition{defaultLadder[1], defaultLadder[3]}, false},
+		{"240", []rendition{renditionForHeight(240)}, false},
+		{"720,abc", nil, true},
+		{"0", nil, true},
+		{"-360", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseLadder(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLadder(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLadder(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
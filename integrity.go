@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+var errDigestMismatch = errors.New("upload doesn't match the digest sent with it")
+
+// contentDigestAlgorithms are the Content-Digest algorithms (RFC 9530) that
+// can be checked; others are ignored.
+var contentDigestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+type expectedDigest struct {
+	name string
+	sum  []byte
+	hash hash.Hash
+}
+
+// uploadDigest hashes an uploaded file as it's copied and checks it against
+// the Content-Digest and Content-MD5 headers, if the client sent any. Uploads
+// are multipart forms, so the digests cover the file rather than the whole
+// request body. The SHA-256 is always computed since later steps need it.
+type uploadDigest struct {
+	sha256   hash.Hash
+	expected []expectedDigest
+}
+
+func newUploadDigest(header http.Header) (*uploadDigest, error) {
+	digest := &uploadDigest{sha256: sha256.New()}
+
+	if values := header.Values("Content-Digest"); len(values) > 0 {
+		found := false
+		for _, member := range strings.Split(strings.Join(values, ","), ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				return nil, fmt.Errorf("malformed Content-Digest member %q", member)
+			}
+			name = strings.ToLower(name)
+			newHash, ok := contentDigestAlgorithms[name]
+			if !ok {
+				continue
+			}
+			// Byte sequences are colon-delimited base64; parameters follow
+			// a semicolon.
+			value, _, _ = strings.Cut(value, ";")
+			if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				return nil, fmt.Errorf("malformed %s digest", name)
+			}
+			if err := digest.expect(name, value[1:len(value)-1], newHash); err != nil {
+				return nil, err
+			}
+			found = true
+		}
+		if !found {
+			return nil, errors.New("no supported algorithm in Content-Digest (sha-256, sha-512)")
+		}
+	}
+
+	if value := header.Get("Content-MD5"); value != "" {
+		if err := digest.expect("md5", value, md5.New); err != nil {
+			return nil, err
+		}
+	}
+	return digest, nil
+}
+
+func (d *uploadDigest) expect(name, encoded string, newHash func() hash.Hash) error {
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("malformed %s digest: %w", name, err)
+	}
+	h := newHash()
+	if len(sum) != h.Size() {
+		return fmt.Errorf("%s digest is %d bytes, want %d", name, len(sum), h.Size())
+	}
+	d.expected = append(d.expected, expectedDigest{name: name, sum: sum, hash: h})
+	return nil
+}
+
+func (d *uploadDigest) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	for _, expected := range d.expected {
+		expected.hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// SHA256 is the hex SHA-256 of everything written so far.
+func (d *uploadDigest) SHA256() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+func (d *uploadDigest) Verify() error {
+	for _, expected := range d.expected {
+		if !bytes.Equal(expected.hash.Sum(nil), expected.sum) {
+			return fmt.Errorf("%w: %s", errDigestMismatch, expected.name)
+		}
+	}
+	return nil
+}
+
+// putChecksum converts a hex SHA-256 to the base64 form PutOptions takes.
+// An empty checksum would make the upload go unchecked, so a malformed one is
+// an error rather than ignored.
+func putChecksum(hexSum string) (string, error) {
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return "", fmt.Errorf("malformed SHA-256 %q: %w", hexSum, err)
+	}
+	if len(sum) != sha256.Size {
+		return "", fmt.Errorf("SHA-256 is %d bytes, want %d", len(sum), sha256.Size)
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
+}
+
+func dataChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// fileChecksum hashes the file at filePath for PutOptions.ChecksumSHA256.
+func fileChecksum(filePath string) (string, error) {
+	hexSum, err := hashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return putChecksum(hexSum)
+}
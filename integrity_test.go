@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadDigest(t *testing.T) {
+	data := []byte("not really a video")
+	other := []byte("something else")
+	b64 := base64.StdEncoding.EncodeToString
+	sha256Of := func(data []byte) string { sum := sha256.Sum256(data); return b64(sum[:]) }
+	sha512Of := func(data []byte) string { sum := sha512.Sum512(data); return b64(sum[:]) }
+	md5Of := func(data []byte) string { sum := md5.Sum(data); return b64(sum[:]) }
+
+	tests := []struct {
+		name      string
+		header    http.Header
+		wantErr   bool
+		wantCheck int
+		mismatch  bool
+	}{
+		{"no headers", http.Header{}, false, 0, false},
+		{"sha-256", http.Header{"Content-Digest": {"sha-256=:" + sha256Of(data) + ":"}}, false, 1, false},
+		{"upper case algorithm", http.Header{"Content-Digest": {"SHA-256=:" + sha256Of(data) + ":"}}, false, 1, false},
+		{"parameters", http.Header{"Content-Digest": {"sha-512=:" + sha512Of(data) + ":;foo=bar"}}, false, 1, false},
+		{"several members", http.Header{"Content-Digest": {"unixsum=:AAAA:, sha-256=:" + sha256Of(data) + ":", "sha-512=:" + sha512Of(data) + ":"}}, false, 2, false},
+		{"md5", http.Header{"Content-Md5": {md5Of(data)}}, false, 1, false},
+		{"digest and md5", http.Header{"Content-Digest": {"sha-256=:" + sha256Of(data) + ":"}, "Content-Md5": {md5Of(data)}}, false, 2, false},
+		{"sha-256 mismatch", http.Header{"Content-Digest": {"sha-256=:" + sha256Of(other) + ":"}}, false, 1, true},
+		{"md5 mismatch", http.Header{"Content-Md5": {md5Of(other)}}, false, 1, true},
+		{"only unsupported", http.Header{"Content-Digest": {"unixsum=:AAAA:"}}, true, 0, false},
+		{"no value", http.Header{"Content-Digest": {"sha-256"}}, true, 0, false},
+		{"no colons", http.Header{"Content-Digest": {"sha-256=" + sha256Of(data)}}, true, 0, false},
+		{"bad base64", http.Header{"Content-Digest": {"sha-256=:not base64!:"}}, true, 0, false},
+		{"wrong length", http.Header{"Content-Digest": {"sha-256=:" + md5Of(data) + ":"}}, true, 0, false},
+		{"bad md5", http.Header{"Content-Md5": {"AAAA"}}, true, 0, false},
+	}
+	for _, tt := range tests {
+		digest, err := newUploadDigest(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: newUploadDigest error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(digest.expected) != tt.wantCheck {
+			t.Errorf("%s: checking %d digests, want %d", tt.name, len(digest.expected), tt.wantCheck)
+		}
+		digest.Write(data[:5])
+		digest.Write(data[5:])
+		err = digest.Verify()
+		if errors.Is(err, errDigestMismatch) != tt.mismatch || (err != nil && !tt.mismatch) {
+			t.Errorf("%s: Verify = %v, want mismatch %v", tt.name, err, tt.mismatch)
+		}
+		sum := sha256.Sum256(data)
+		if got := digest.SHA256(); got != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: SHA256 = %s, want %x", tt.name, got, sum)
+		}
+	}
+}
+
+func TestPutChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("video"))
+	tests := []struct {
+		hexSum  string
+		want    string
+		wantErr bool
+	}{
+		{hex.EncodeToString(sum[:]), base64.StdEncoding.EncodeToString(sum[:]), false},
+		{"", "", true},
+		{"not hex", "", true},
+		{hex.EncodeToString(sum[:])[:63], "", true},
+		{hex.EncodeToString(sum[:16]), "", true},
+	}
+	for _, tt := range tests {
+		got, err := putChecksum(tt.hexSum)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("putChecksum(%q) = %q, %v; want %q, error %v", tt.hexSum, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	filePath := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(filePath, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileChecksum(filePath)
+	if err != nil || got != dataChecksum([]byte("video")) {
+		t.Errorf("fileChecksum = %q, %v; want %q", got, err, dataChecksum([]byte("video")))
+	}
+}
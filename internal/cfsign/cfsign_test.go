@@ -0,0 +1,242 @@
+package cfsign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) (*Signer, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSigner("K2JCJMDEHXQW5F", key), key
+}
+
+// fromURLSafeBase64 reverses urlSafeBase64.
+func fromURLSafeBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(s))
+	if err != nil {
+		t.Fatalf("decoding %q: %v", s, err)
+	}
+	return data
+}
+
+func verify(t *testing.T, key *rsa.PrivateKey, policy []byte, signature string) {
+	t.Helper()
+	hash := sha1.Sum(policy)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hash[:], fromURLSafeBase64(t, signature)); err != nil {
+		t.Errorf("signature doesn't verify against %s: %v", policy, err)
+	}
+}
+
+func TestURLSafeBase64(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte{}, ""},
+		{[]byte("a"), "YQ__"},
+		{[]byte{0xfb, 0xff}, "-~8_"},
+		{[]byte{0xff, 0xff, 0xff}, "~~~~"},
+	}
+	for _, tt := range tests {
+		if got := urlSafeBase64(tt.data); got != tt.want {
+			t.Errorf("urlSafeBase64(%x) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyEncode(t *testing.T) {
+	expires := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   string
+	}{
+		{
+			"canned",
+			NewCannedPolicy("https://cdn.example.com/a.mp4", expires),
+			`{"Statement":[{"Resource":"https://cdn.example.com/a.mp4","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000}}}]}`,
+		},
+		{
+			"custom with wildcard",
+			NewCustomPolicy("https://cdn.example.com/*/out/*", expires, time.Time{}, ""),
+			`{"Statement":[{"Resource":"https://cdn.example.com/*/out/*","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000}}}]}`,
+		},
+		{
+			"custom with start and IP",
+			NewCustomPolicy("https://cdn.example.com/a?b=1&c=2", expires, time.Unix(1600000000, 0), "192.0.2.0/24"),
+			`{"Statement":[{"Resource":"https://cdn.example.com/a?b=1&c=2","Condition":{"DateLessThan":{"AWS:EpochTime":1700000000},"DateGreaterThan":{"AWS:EpochTime":1600000000},"IpAddress":{"AWS:SourceIp":"192.0.2.0/24"}}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.policy.encode()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: encode() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := (&Policy{}).encode(); err == nil {
+		t.Error("encoding a policy without statements succeeded")
+	}
+}
+
+func TestSignCannedURL(t *testing.T) {
+	signer, key := newTestSigner(t)
+	expires := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		rawURL     string
+		wantPrefix string
+	}{
+		{"https://cdn.example.com/landscape/a.mp4", "https://cdn.example.com/landscape/a.mp4?"},
+		{"https://cdn.example.com/a.mp4?download=1", "https://cdn.example.com/a.mp4?download=1&"},
+	}
+	for _, tt := range tests {
+		signed, err := signer.SignCannedURL(tt.rawURL, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(signed, tt.wantPrefix) {
+			t.Errorf("SignCannedURL(%q) = %q, want prefix %q", tt.rawURL, signed, tt.wantPrefix)
+		}
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		if query.Get("Expires") != "1700000000" || query.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" || query.Has("Policy") {
+			t.Errorf("SignCannedURL(%q) has query %v", tt.rawURL, query)
+		}
+		// CloudFront rebuilds the canned policy from the URL it was asked for.
+		policy, _ := NewCannedPolicy(tt.rawURL, expires).encode()
+		verify(t, key, policy, query.Get("Signature"))
+	}
+}
+
+func TestSignCustomURL(t *testing.T) {
+	signer, key := newTestSigner(t)
+	policy := NewCustomPolicy("https://cdn.example.com/hls/out/*", time.Unix(1700000000, 0), time.Time{}, "")
+
+	signed, err := signer.SignCustomURL("https://cdn.example.com/hls/out/master.m3u8", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Has("Expires") || query.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" {
+		t.Errorf("SignCustomURL has query %v", query)
+	}
+	encoded, _ := policy.encode()
+	if got := fromURLSafeBase64(t, query.Get("Policy")); string(got) != string(encoded) {
+		t.Errorf("Policy = %s, want %s", got, encoded)
+	}
+	verify(t, key, encoded, query.Get("Signature"))
+}
+
+func TestSignedCookies(t *testing.T) {
+	signer, key := newTestSigner(t)
+	expires := time.Unix(1700000000, 0)
+	policy := NewCustomPolicy("https://cdn.example.com/*/out/*", expires, time.Time{}, "")
+
+	custom, err := signer.SignedCookies(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canned, err := signer.CannedCookies("https://cdn.example.com/a.mp4", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cookies map[string]string
+		want    []string
+	}{
+		{"custom", cookieValues(custom), []string{"CloudFront-Policy", "CloudFront-Signature", "CloudFront-Key-Pair-Id"}},
+		{"canned", cookieValues(canned), []string{"CloudFront-Expires", "CloudFront-Signature", "CloudFront-Key-Pair-Id"}},
+	}
+	for _, tt := range tests {
+		if len(tt.cookies) != len(tt.want) {
+			t.Errorf("%s: got cookies %v, want %v", tt.name, tt.cookies, tt.want)
+		}
+		for _, name := range tt.want {
+			if tt.cookies[name] == "" {
+				t.Errorf("%s: no %s cookie", tt.name, name)
+			}
+		}
+	}
+
+	encoded, _ := policy.encode()
+	verify(t, key, encoded, cookieValues(custom)["CloudFront-Signature"])
+	cannedPolicy, _ := NewCannedPolicy("https://cdn.example.com/a.mp4", expires).encode()
+	verify(t, key, cannedPolicy, cookieValues(canned)["CloudFront-Signature"])
+	if got := cookieValues(canned)["CloudFront-Expires"]; got != "1700000000" {
+		t.Errorf("CloudFront-Expires = %q", got)
+	}
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	_, key := newTestSigner(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"PKCS#1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), false},
+		{"PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), false},
+		{"not PEM", []byte("not a key"), true},
+		{"garbage DER", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), true},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, strconv.Itoa(i)+".pem")
+		if err := os.WriteFile(path, tt.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadPrivateKey(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: LoadPrivateKey error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(key) {
+			t.Errorf("%s: loaded a different key", tt.name)
+		}
+	}
+}
+
+func cookieValues(cookies []*http.Cookie) map[string]string {
+	values := map[string]string{}
+	for _, cookie := range cookies {
+		values[cookie.Name] = cookie.Value
+	}
+	return values
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ContentObject is one entry of the content-addressed index: the processed
+// outputs stored for an upload with the given SHA-256, shared by every video
+// uploaded with the same bytes.
+type ContentObject struct {
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoKey  string    `json:"video_key"`
+	HLSKey    *string   `json:"hls_key"`
+	DASHKey   *string   `json:"dash_key"`
+	// VideoSHA256 is the hash of the MP4 at VideoKey.
+	VideoSHA256 *string `json:"video_sha256"`
+	FileSize    int64   `json:"file_size"`
+	RefCount    int     `json:"ref_count"`
+}
+
+const contentColumns = `
+		sha256,
+		created_at,
+		updated_at,
+		video_key,
+		hls_key,
+		dash_key,
+		video_sha256,
+		file_size,
+		ref_count
+`
+
+func scanContent(row rowScanner) (ContentObject, error) {
+	var content ContentObject
+	err := row.Scan(
+		&content.SHA256,
+		&content.CreatedAt,
+		&content.UpdatedAt,
+		&content.VideoKey,
+		&content.HLSKey,
+		&content.DASHKey,
+		&content.VideoSHA256,
+		&content.FileSize,
+		&content.RefCount,
+	)
+	return content, err
+}
+
+// AcquireContent takes a reference on the entry for sha256 and returns it,
+// or a zero ContentObject when nothing with that hash has been processed.
+func (c Client) AcquireContent(sha256 string) (ContentObject, error) {
+	query := `
+	UPDATE content_index
+	SET
+		ref_count = ref_count + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE sha256 = ?
+	RETURNING` + contentColumns
+
+	content, err := scanContent(c.db.QueryRow(query, sha256))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ContentObject{}, nil
+		}
+		return ContentObject{}, err
+	}
+	return content, nil
+}
+
+// GetAllContent returns every entry of the index.
+func (c Client) GetAllContent() ([]ContentObject, error) {
+	rows, err := c.db.Query(`SELECT` + contentColumns + `FROM content_index`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contents := []ContentObject{}
+	for rows.Next() {
+		content, err := scanContent(rows)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
+
+// RegisterContent adds freshly processed outputs to the index with one
+// reference. If a concurrent upload of the same bytes got there first, that
+// entry gains the reference instead and is returned with created false, so
+// the caller can switch to it and drop its own copy.
+func (c Client) RegisterContent(content ContentObject) (ContentObject, bool, error) {
+	query := `
+	INSERT INTO content_index (
+		sha256,
+		created_at,
+		updated_at,
+		video_key,
+		hls_key,
+		dash_key,
+		video_sha256,
+		file_size,
+		ref_count
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 1)
+	ON CONFLICT (sha256) DO NOTHING
+	`
+	result, err := c.db.Exec(query, content.SHA256, content.VideoKey, content.HLSKey, content.DASHKey, content.VideoSHA256, content.FileSize)
+	if err != nil {
+		return ContentObject{}, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return ContentObject{}, false, err
+	}
+	if inserted == 1 {
+		content.RefCount = 1
+		return content, true, nil
+	}
+	existing, err := c.AcquireContent(content.SHA256)
+	return existing, false, err
+}
+
+// ReleaseContent drops one reference on the entry for sha256. When that was
+// the last one the entry is removed and blobs, its stored outputs, are
+// recorded as pending deletion in the same transaction.
+func (c Client) ReleaseContent(sha256 string, blobs []BlobRef) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	unused, err := releaseContent(tx, sha256)
+	if err != nil {
+		return nil, err
+	}
+	pending := []PendingDeletion{}
+	if unused {
+		pending, err = insertPendingDeletions(tx, blobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// releaseContent decrements the reference count and deletes the entry once
+// it reaches zero, reporting whether the outputs are now unused. A missing
+// entry counts as unused.
+func releaseContent(tx *sqlTx, sha256 string) (bool, error) {
+	var refCount int
+	err := tx.QueryRow(`
+	UPDATE content_index
+	SET
+		ref_count = ref_count - 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE sha256 = ?
+	RETURNING ref_count
+	`, sha256).Scan(&refCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if refCount > 0 {
+		return false, nil
+	}
+	_, err = tx.Exec(`DELETE FROM content_index WHERE sha256 = ?`, sha256)
+	return true, err
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL engine behind a Client. Queries are written once with
+// ? placeholders and types both engines accept; the few statements that
+// differ switch on it.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// parseDSN picks the engine from the DSN scheme: postgres:// and
+// postgresql:// URLs go to Postgres, anything else is a SQLite database path.
+func parseDSN(dsn string) (driverName string, d dialect) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return "postgres", dialectPostgres
+	}
+	return "sqlite3", dialectSQLite
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres. None of the
+// queries contain a literal question mark.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// timestamp converts t for comparison with a timestamp column. SQLite keeps
+// CURRENT_TIMESTAMP values as text to the second, and compares them as text.
+func (d dialect) timestamp(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t
+}
+
+// sqlDB and sqlTx rebind queries for the dialect, so call sites can use
+// them exactly like *sql.DB and *sql.Tx.
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db sqlDB) Begin() (*sqlTx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db sqlDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: db.dialect}, nil
+}
+
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
@@ -0,0 +1,230 @@
+package database
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn        string
+		wantDriver string
+		want       dialect
+	}{
+		{"tubely.db", "sqlite3", dialectSQLite},
+		{"file:tubely.db?_foreign_keys=on", "sqlite3", dialectSQLite},
+		{"postgres://tubely@localhost/tubely", "postgres", dialectPostgres},
+		{"postgresql://tubely@localhost/tubely?sslmode=disable", "postgres", dialectPostgres},
+	}
+	for _, tt := range tests {
+		driver, d := parseDSN(tt.dsn)
+		if driver != tt.wantDriver || d != tt.want {
+			t.Errorf("parseDSN(%q) = %s, %s; want %s, %s", tt.dsn, driver, d, tt.wantDriver, tt.want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		d     dialect
+		query string
+		want  string
+	}{
+		{dialectPostgres, "SELECT * FROM videos WHERE id = ?", "SELECT * FROM videos WHERE id = $1"},
+		{dialectPostgres, "UPDATE jobs SET status = ? WHERE id IN (?, ?) LIMIT ?", "UPDATE jobs SET status = $1 WHERE id IN ($2, $3) LIMIT $4"},
+		{dialectPostgres, "SELECT 1", "SELECT 1"},
+		{dialectPostgres, "SELECT 'café' WHERE x = ?", "SELECT 'café' WHERE x = $1"},
+		{dialectSQLite, "SELECT * FROM videos WHERE id = ?", "SELECT * FROM videos WHERE id = ?"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.rebind(tt.query); got != tt.want {
+			t.Errorf("%s rebind(%q) = %q, want %q", tt.d, tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestContentIndexRefCounts covers the UPDATE ... RETURNING statements the
+// content index relies on.
+func TestContentIndexRefCounts(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+
+		missing, err := c.AcquireContent("missing")
+		if err != nil || missing.SHA256 != "" {
+			t.Fatalf("AcquireContent of a missing entry = %+v, %v", missing, err)
+		}
+
+		hlsKey := "hls/abc/master.m3u8"
+		registered, created, err := c.RegisterContent(ContentObject{SHA256: "abc", VideoKey: "abc.mp4", HLSKey: &hlsKey, FileSize: 42})
+		if err != nil || !created || registered.RefCount != 1 {
+			t.Fatalf("RegisterContent = %+v, %v, %v", registered, created, err)
+		}
+		again, created, err := c.RegisterContent(ContentObject{SHA256: "abc", VideoKey: "duplicate.mp4"})
+		if err != nil || created || again.VideoKey != "abc.mp4" || again.RefCount != 2 {
+			t.Fatalf("registering the same content again = %+v, %v, %v", again, created, err)
+		}
+
+		acquired, err := c.AcquireContent("abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired.RefCount != 3 || acquired.HLSKey == nil || *acquired.HLSKey != hlsKey || acquired.FileSize != 42 {
+			t.Errorf("AcquireContent = %+v", acquired)
+		}
+
+		refs := []BlobRef{{Store: "blob", Key: "abc.mp4"}}
+		for i := 0; i < 2; i++ {
+			pending, err := c.ReleaseContent("abc", refs)
+			if err != nil || len(pending) != 0 {
+				t.Fatalf("release %d = %v, %v; want nothing pending", i+1, pending, err)
+			}
+		}
+		pending, err := c.ReleaseContent("abc", refs)
+		if err != nil || len(pending) != 1 || pending[0].Key != "abc.mp4" {
+			t.Fatalf("last release = %v, %v; want abc.mp4 pending", pending, err)
+		}
+		if gone, err := c.AcquireContent("abc"); err != nil || gone.SHA256 != "" {
+			t.Errorf("entry survived its last release: %+v, %v", gone, err)
+		}
+	})
+}
+
+// TestClaimNextJobConcurrently checks that workers claiming at the same time
+// never get the same job, which on Postgres is down to FOR UPDATE SKIP
+// LOCKED.
+func TestClaimNextJobConcurrently(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		const jobCount = 8
+		for i := 0; i < jobCount; i++ {
+			video := newTestVideo(t, c)
+			if _, err := c.CreateJob(CreateJobParams{VideoID: video.ID, UserID: video.UserID, InputPath: "input.mp4"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			claimed = map[uuid.UUID]int{}
+		)
+		for i := 0; i < jobCount; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job, err := c.ClaimNextJob()
+				if err != nil {
+					t.Errorf("ClaimNextJob: %v", err)
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+				if job.Status != JobStatusRunning || job.Attempts != 1 {
+					t.Errorf("claimed job is %s after %d attempts", job.Status, job.Attempts)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(claimed) != jobCount {
+			t.Errorf("%d workers claimed %d distinct jobs: %v", jobCount, len(claimed), claimed)
+		}
+		if _, ok := claimed[uuid.Nil]; ok {
+			t.Error("a worker found the queue empty")
+		}
+		if job, err := c.ClaimNextJob(); err != nil || job.ID != uuid.Nil {
+			t.Errorf("ClaimNextJob on an empty queue = %v, %v", job.ID, err)
+		}
+	})
+}
+
+func TestSearchVideos(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		create := func(userID uuid.UUID, title, description string, public bool) Video {
+			t.Helper()
+			video, err := c.CreateVideo(CreateVideoParams{Title: title, Description: description, Public: public, UserID: userID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return video
+		}
+		alice, err := c.CreateUser(CreateUserParams{Email: "alice@example.com", Password: "unused"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bob, err := c.CreateUser(CreateUserParams{Email: "bob@example.com", Password: "unused"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns := create(alice.ID, "Go concurrency patterns", "Channels and worker pools", true)
+		private := create(alice.ID, "Concurrent Go", "Unlisted draft", false)
+		talk := create(bob.ID, "Gopher talk", "Concurrency in depth", true)
+		create(bob.ID, "Cooking pasta", "Nothing to do with it", true)
+
+		search := func(params SearchVideosParams) []uuid.UUID {
+			t.Helper()
+			ids := []uuid.UUID{}
+			for {
+				page, err := c.SearchVideos(params)
+				if err != nil {
+					t.Fatalf("SearchVideos(%+v): %v", params, err)
+				}
+				for _, hit := range page.Hits {
+					ids = append(ids, hit.ID)
+				}
+				if page.Next == nil {
+					return ids
+				}
+				params.After = page.Next
+			}
+		}
+
+		tests := []struct {
+			name   string
+			params SearchVideosParams
+			want   []uuid.UUID
+		}{
+			// Title matches rank above description matches.
+			{"public", SearchVideosParams{Scope: SearchScopePublic, Query: "conc go", Limit: 10}, []uuid.UUID{patterns.ID, talk.ID}},
+			{"public, one per page", SearchVideosParams{Scope: SearchScopePublic, Query: "conc go", Limit: 1}, []uuid.UUID{patterns.ID, talk.ID}},
+			{"own", SearchVideosParams{Scope: SearchScopeOwn, UserID: alice.ID, Query: "unlisted", Limit: 10}, []uuid.UUID{private.ID}},
+			{"every word has to match", SearchVideosParams{Scope: SearchScopePublic, Query: "go pasta", Limit: 10}, []uuid.UUID{}},
+			{"syntax is ignored", SearchVideosParams{Scope: SearchScopePublic, Query: `"pools" OR -x:*`, Limit: 10}, []uuid.UUID{}},
+			{"syntax is ignored, words match", SearchVideosParams{Scope: SearchScopePublic, Query: `"pools" & worker:*`, Limit: 10}, []uuid.UUID{patterns.ID}},
+		}
+		for _, tt := range tests {
+			got := search(tt.params)
+			if len(got) != len(tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+					break
+				}
+			}
+		}
+
+		page, err := c.SearchVideos(SearchVideosParams{Scope: SearchScopePublic, Query: "patterns", Limit: 10})
+		if err != nil || len(page.Hits) != 1 {
+			t.Fatalf("searching patterns = %v, %v", page.Hits, err)
+		}
+		highlight := page.Hits[0].TitleHighlight
+		if !strings.Contains(highlight, SearchMatchStart+"patterns"+SearchMatchEnd) {
+			t.Errorf("title highlight %q doesn't mark the match", highlight)
+		}
+		if unmarked := strings.NewReplacer(SearchMatchStart, "", SearchMatchEnd, "").Replace(highlight); unmarked != patterns.Title {
+			t.Errorf("title highlight %q isn't the title", highlight)
+		}
+
+		if _, err := c.SearchVideos(SearchVideosParams{Scope: SearchScopePublic, Query: " -*: ", Limit: 10}); err != ErrEmptySearch {
+			t.Errorf("searching for no words = %v, want ErrEmptySearch", err)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testDatabaseURLEnv names a Postgres database, e.g.
+// postgres://postgres@localhost/tubely_test?sslmode=disable, to run the
+// engine tests against as well as SQLite. Each test gets a schema of its own
+// there, dropped afterwards. Without it, or when the server can't be
+// reached, the Postgres runs are skipped.
+const testDatabaseURLEnv = "TEST_DATABASE_URL"
+
+// forEachEngine runs test once per engine, each with the DSN of a fresh,
+// empty database.
+func forEachEngine(t *testing.T, test func(t *testing.T, dsn string)) {
+	t.Helper()
+	for _, d := range []dialect{dialectSQLite, dialectPostgres} {
+		t.Run(string(d), func(t *testing.T) {
+			test(t, newTestDSN(t, d))
+		})
+	}
+}
+
+func newTestDSN(t *testing.T, d dialect) string {
+	t.Helper()
+	if d == dialectSQLite {
+		return filepath.Join(t.TempDir(), "tubely.db")
+	}
+
+	baseDSN := os.Getenv(testDatabaseURLEnv)
+	if baseDSN == "" {
+		t.Skipf("%s not set", testDatabaseURLEnv)
+	}
+	admin, err := Open(baseDSN)
+	if err != nil {
+		t.Fatalf("opening %s: %v", testDatabaseURLEnv, err)
+	}
+	t.Cleanup(func() { admin.db.Close() })
+	if err := admin.db.Ping(); err != nil {
+		t.Skipf("Postgres isn't available: %v", err)
+	}
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	// lib/pq passes options it doesn't know on to the server as settings.
+	u, err := url.Parse(baseDSN)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", testDatabaseURLEnv, err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// newTestClientAt returns a client on the database at dsn, migrated.
+func newTestClientAt(t *testing.T, dsn string) Client {
+	t.Helper()
+	c, err := NewClient(dsn)
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+// newTestClient returns a client on a fresh, migrated SQLite database.
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	return newTestClientAt(t, newTestDSN(t, dialectSQLite))
+}
+
+func newTestVideo(t *testing.T, c Client) Video {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "unused"})
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "Test video", UserID: user.ID})
+	if err != nil {
+		t.Fatalf("creating video: %v", err)
+	}
+	return video
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Error     *string   `json:"error"`
+	Attempts  int       `json:"attempts"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	InputPath string    `json:"-"`
+	// InputSHA256 is the hex SHA-256 of the input file, computed while it
+	// was received.
+	InputSHA256 string `json:"-"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		status,
+		error,
+		attempts,
+		input_path,
+		input_sha256
+`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.VideoID,
+		&job.UserID,
+		&job.Status,
+		&job.Error,
+		&job.Attempts,
+		&job.InputPath,
+		&job.InputSHA256,
+	)
+	return job, err
+}
+
+// CreateJob queues a processing job and marks its video as uploaded in the
+// same transaction.
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Job{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		status,
+		attempts,
+		input_path,
+		input_sha256
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?)
+	`
+	_, err = tx.Exec(query, id, params.VideoID, params.UserID, JobStatusQueued, params.InputPath, params.InputSHA256)
+	if err != nil {
+		return Job{}, err
+	}
+
+	videoQuery := `
+	UPDATE videos
+	SET
+		status = ?,
+		status_error = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err = tx.Exec(videoQuery, VideoStatusUploaded, params.VideoID)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextJob atomically moves the oldest queued job to running. It returns
+// a zero Job when the queue is empty.
+func (c Client) ClaimNextJob() (Job, error) {
+	// SQLite serializes writers; on Postgres concurrent workers would pick
+	// the same row without the lock.
+	lock := ""
+	if c.db.dialect == dialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE status = ?
+		ORDER BY created_at ASC
+		LIMIT 1
+		` + lock + `
+	)
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.db.QueryRow(query, JobStatusRunning, JobStatusQueued))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func (c Client) FinishJob(id uuid.UUID, status JobStatus, jobError *string) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, jobError, id)
+	return err
+}
+
+// RequeueInterruptedJobs puts jobs that were running when the server stopped
+// back on the queue, giving up on ones that already used maxAttempts.
+func (c Client) RequeueInterruptedJobs(maxAttempts int) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = CASE WHEN attempts < ? THEN ? ELSE ? END,
+		error = CASE WHEN attempts < ? THEN error ELSE 'interrupted too many times' END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	_, err := c.db.Exec(query, maxAttempts, JobStatusQueued, JobStatusFailed, maxAttempts, JobStatusRunning)
+	return err
+}
+
+// GetUnfinishedJobInputPaths returns the input files of jobs that are still
+// queued or running, which must be kept until the job finishes.
+func (c Client) GetUnfinishedJobInputPaths() ([]string, error) {
+	query := `
+	SELECT input_path
+	FROM jobs
+	WHERE status IN (?, ?)
+	`
+	rows, err := c.db.Query(query, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var inputPath string
+		if err := rows.Scan(&inputPath); err != nil {
+			return nil, err
+		}
+		paths = append(paths, inputPath)
+	}
+	return paths, rows.Err()
+}
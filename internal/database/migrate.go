@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are pairs of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, applied in version order. Each dialect has its
+// own directory holding the same versions under the same names, so a
+// version means one point in the schema's history on every engine. Where a
+// dialect has nothing to do, such as SQLite changes that predate the
+// Postgres baseline, its scripts are comments saying why.
+//
+// An up script starting with "-- requires: fts5" needs SQLite built with
+// FTS5. Builds without it leave the migration pending and refuse to open a
+// database it was applied to, since its triggers would fail every write.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+	// needsFTS5 is set by a "-- requires: fts5" line opening the up script.
+	needsFTS5 bool
+}
+
+const requiresFTS5 = "-- requires: fts5\n"
+
+// MigrationState is a known migration, or one recorded by a newer build, and
+// when it was applied. AppliedAt is nil for pending migrations.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/"+string(d)+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.up.sql or .down.sql", base)
+		}
+		versionString, name, _ := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(versionString)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", base, err)
+		}
+		data, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.up = string(data)
+			migration.needsFTS5 = strings.HasPrefix(migration.up, requiresFTS5)
+		} else {
+			migration.down = string(data)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func (c Client) ensureMigrationTable() error {
+	timestampType := "TIMESTAMP"
+	if c.db.dialect == dialectPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestampType + ` DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	return err
+}
+
+// MigrationStatus lists every known migration along with any applied ones
+// this build doesn't know about, in version order.
+func (c Client) MigrationStatus() ([]MigrationState, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query(`SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]MigrationState{}
+	for rows.Next() {
+		var state MigrationState
+		var appliedAt time.Time
+		if err := rows.Scan(&state.Version, &state.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		state.AppliedAt = &appliedAt
+		applied[state.Version] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if recorded, ok := applied[migration.Version]; ok {
+			state.AppliedAt = recorded.AppliedAt
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, unknown := range applied {
+		states = append(states, unknown)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+	return states, nil
+}
+
+// MigrateUp applies every pending migration, oldest first, and returns the
+// ones it applied.
+func (c Client) MigrateUp() ([]Migration, error) {
+	states, err := c.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, state := range states {
+		if state.needsFTS5 && !c.fts5 {
+			if state.AppliedAt != nil {
+				return applied, fmt.Errorf("migration %d_%s needs SQLite with FTS5; build with -tags sqlite_fts5", state.Version, state.Name)
+			}
+			continue
+		}
+		if state.AppliedAt != nil {
+			continue
+		}
+		err := c.runMigration(func(tx *sqlTx) error {
+			if _, err := tx.Exec(state.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, state.Version, state.Name)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", state.Version, state.Name, err)
+		}
+		applied = append(applied, state.Migration)
+	}
+	return applied, nil
+}
+
+// MigrateDown rolls back the newest steps applied migrations and returns
+// them, newest first.
+func (c Client) MigrateDown(steps int) ([]Migration, error) {
+	states, err := c.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	rolledBack := []Migration{}
+	for i := len(states) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		state := states[i]
+		if state.AppliedAt == nil {
+			continue
+		}
+		if state.down == "" {
+			return rolledBack, fmt.Errorf("migration %d_%s was applied by a newer build and can't be rolled back by this one", state.Version, state.Name)
+		}
+		err := c.runMigration(func(tx *sqlTx) error {
+			if _, err := tx.Exec(state.down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, state.Version)
+			return err
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("rolling back migration %d_%s: %w", state.Version, state.Name, err)
+		}
+		rolledBack = append(rolledBack, state.Migration)
+	}
+	return rolledBack, nil
+}
+
+// runMigration runs apply in its own transaction. On SQLite, foreign key
+// enforcement, if on, is suspended for the duration, as it requires for
+// rebuilding a table, and checked before committing instead.
+func (c Client) runMigration(apply func(tx *sqlTx) error) error {
+	if c.db.dialect != dialectSQLite {
+		tx, err := c.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := apply(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	rawTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx := &sqlTx{Tx: rawTx, dialect: c.db.dialect}
+	defer tx.Rollback()
+
+	if err := apply(tx); err != nil {
+		return err
+	}
+
+	if foreignKeys {
+		rows, err := tx.Query("PRAGMA foreign_key_check")
+		if err != nil {
+			return err
+		}
+		violation := rows.Next()
+		rows.Close()
+		if violation {
+			return errors.New("migration leaves foreign key violations")
+		}
+	}
+
+	return tx.Commit()
+}
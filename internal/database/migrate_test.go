@@ -0,0 +1,209 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// schemaOf describes every table, column, index and constraint in the
+// database apart from the migration bookkeeping, so two schemas can be
+// compared.
+func schemaOf(t *testing.T, c Client) []string {
+	t.Helper()
+	query := `
+	SELECT type || ' ' || name || ': ' || COALESCE(sql, '')
+	FROM sqlite_master
+	WHERE name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'
+	ORDER BY 1
+	`
+	if c.db.dialect == dialectPostgres {
+		query = `
+		SELECT table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '') || ' ' || COALESCE(generation_expression, '')
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name != 'schema_migrations'
+		UNION ALL
+		SELECT indexdef
+		FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename != 'schema_migrations'
+		UNION ALL
+		SELECT conrelid::regclass || ' ' || conname || ' ' || pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE connamespace = current_schema()::regnamespace AND conrelid != 'schema_migrations'::regclass
+		ORDER BY 1
+		`
+	}
+	rows, err := c.db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := []string{}
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			t.Fatal(err)
+		}
+		schema = append(schema, object)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestMigrationVersionsMatch(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(migrations []Migration) []string {
+		names := []string{}
+		for _, migration := range migrations {
+			names = append(names, fmt.Sprintf("%04d_%s", migration.Version, migration.Name))
+		}
+		return names
+	}
+	if !reflect.DeepEqual(names(sqlite), names(postgres)) {
+		t.Errorf("dialects have different migrations:\n  sqlite %v\npostgres %v", names(sqlite), names(postgres))
+	}
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	forEachEngine(t, testMigrationsRoundTrip)
+}
+
+func testMigrationsRoundTrip(t *testing.T, dsn string) {
+	c, err := Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	all, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without FTS5 the search index stays pending.
+	migrations := []Migration{}
+	for _, migration := range all {
+		if !migration.needsFTS5 || c.fts5 {
+			migrations = append(migrations, migration)
+		}
+	}
+
+	applied, err := c.MigrateUp()
+	if err != nil {
+		t.Fatalf("MigrateUp on an empty database: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	latest := schemaOf(t, c)
+
+	// Rolling back any number of steps and migrating up again has to land
+	// on the same schema.
+	for steps := 1; steps <= len(migrations); steps++ {
+		rolledBack, err := c.MigrateDown(steps)
+		if err != nil {
+			t.Fatalf("MigrateDown(%d): %v", steps, err)
+		}
+		if len(rolledBack) != steps || rolledBack[0].Version != migrations[len(migrations)-1].Version {
+			t.Fatalf("MigrateDown(%d) rolled back %v", steps, rolledBack)
+		}
+		if steps == len(migrations) {
+			if schema := schemaOf(t, c); len(schema) != 0 {
+				t.Errorf("rolling back every migration left %v", schema)
+			}
+		}
+
+		applied, err := c.MigrateUp()
+		if err != nil {
+			t.Fatalf("MigrateUp after rolling back %d: %v", steps, err)
+		}
+		if len(applied) != steps {
+			t.Fatalf("MigrateUp after rolling back %d applied %d", steps, len(applied))
+		}
+		if schema := schemaOf(t, c); !reflect.DeepEqual(schema, latest) {
+			t.Errorf("schema after rolling back %d and migrating up differs:\n got %q\nwant %q", steps, schema, latest)
+		}
+	}
+
+	states, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if state.AppliedAt == nil && (!state.needsFTS5 || c.fts5) {
+			t.Errorf("migration %d_%s isn't recorded as applied", state.Version, state.Name)
+		}
+	}
+}
+
+// TestMigrateStarterDatabase adopts a database created before migrations
+// were versioned, when the server made its three tables on startup.
+func TestMigrateStarterDatabase(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tubely.db")
+	starter, err := Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, processed, draft := uuid.New(), uuid.New(), uuid.New()
+	for _, statement := range []string{`
+		CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			password TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL
+		)`, `
+		CREATE TABLE refresh_tokens (
+			token TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP,
+			user_id TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`, `
+		CREATE TABLE videos (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			video_url TEXT TEXT,
+			user_id INTEGER,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+	} {
+		if _, err := starter.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := starter.db.Exec(`INSERT INTO users (id, password, email) VALUES (?, 'unused', 'starter@example.com')`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := starter.db.Exec(`INSERT INTO videos (id, title, description, video_url, user_id) VALUES (?, 'Processed', '', 'https://tubely.s3.us-east-2.amazonaws.com/landscape/a.mp4', ?), (?, 'Draft', '', NULL, ?)`, processed, userID, draft, userID); err != nil {
+		t.Fatal(err)
+	}
+	starter.db.Close()
+
+	c := newTestClientAt(t, dsn)
+	for id, want := range map[uuid.UUID]VideoStatus{processed: VideoStatusReady, draft: VideoStatusDraft} {
+		video, err := c.GetVideo(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if video.Status != want || video.UserID != userID {
+			t.Errorf("video %s migrated as %s owned by %s, want %s owned by %s", video.Title, video.Status, video.UserID, want, userID)
+		}
+	}
+}
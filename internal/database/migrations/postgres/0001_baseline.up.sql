@@ -0,0 +1,108 @@
+-- The schema SQLite reaches after 0011, with native UUID, timestamp and
+-- 64-bit integer types. Postgres support arrived after those migrations, so
+-- it starts here.
+
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id UUID NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	thumbnail_variants TEXT,
+	video_url TEXT,
+	hls_url TEXT,
+	dash_url TEXT,
+	status TEXT NOT NULL DEFAULT 'draft',
+	status_error TEXT,
+	duration_seconds DOUBLE PRECISION,
+	width INTEGER,
+	height INTEGER,
+	frame_rate DOUBLE PRECISION,
+	video_codec TEXT,
+	audio_codec TEXT,
+	bit_rate BIGINT,
+	rotation INTEGER,
+	file_size BIGINT,
+	content_sha256 TEXT,
+	video_sha256 TEXT,
+	user_id UUID,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS pending_deletions (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+);
+
+CREATE TABLE IF NOT EXISTS uploads (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL,
+	user_id UUID NOT NULL,
+	upload_length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL,
+	user_id UUID NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	input_path TEXT NOT NULL,
+	input_sha256 TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL,
+	url TEXT NOT NULL,
+	position_seconds DOUBLE PRECISION NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS content_index (
+	sha256 TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_key TEXT NOT NULL,
+	hls_key TEXT,
+	dash_key TEXT,
+	video_sha256 TEXT,
+	file_size BIGINT NOT NULL,
+	ref_count INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Postgres support arrived after this migration; its baseline already
+-- includes it.
@@ -0,0 +1,6 @@
+-- One index per sort offered by ListVideos, ending in id to match its
+-- keyset ordering.
+CREATE INDEX IF NOT EXISTS videos_user_created_at ON videos (user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS videos_user_updated_at ON videos (user_id, updated_at, id);
+CREATE INDEX IF NOT EXISTS videos_user_title ON videos (user_id, title, id);
+CREATE INDEX IF NOT EXISTS videos_user_duration ON videos (user_id, (COALESCE(duration_seconds, 0)), id);
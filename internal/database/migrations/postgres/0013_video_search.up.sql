@@ -0,0 +1,8 @@
+-- Full-text index over video titles and descriptions for SearchVideos.
+-- The simple configuration doesn't stem, so prefix queries match what was
+-- typed, as with the SQLite tokenizer.
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', title), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS videos_search ON videos USING GIN (search_vector);
@@ -0,0 +1 @@
+ALTER TABLE pending_deletions DROP COLUMN next_attempt_at;
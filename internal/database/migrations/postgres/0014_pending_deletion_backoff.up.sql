@@ -0,0 +1,3 @@
+-- Failed deletions wait until next_attempt_at before being retried, backing
+-- off further after each failure. NULL means due now.
+ALTER TABLE pending_deletions ADD COLUMN next_attempt_at TIMESTAMPTZ;
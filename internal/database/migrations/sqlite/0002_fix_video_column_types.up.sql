@@ -0,0 +1,22 @@
+-- videos.video_url was declared "TEXT TEXT" and videos.user_id INTEGER,
+-- although user ids are TEXT everywhere else. SQLite can't change a column's
+-- type in place, so the table is rebuilt.
+
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
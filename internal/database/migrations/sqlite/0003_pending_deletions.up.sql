@@ -0,0 +1,10 @@
+-- Objects whose deletion failed, retried in the background.
+CREATE TABLE pending_deletions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+);
@@ -0,0 +1,3 @@
+ALTER TABLE videos DROP COLUMN status_error;
+ALTER TABLE videos DROP COLUMN status;
+DROP TABLE jobs;
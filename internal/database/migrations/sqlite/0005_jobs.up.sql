@@ -0,0 +1,20 @@
+-- The processing queue, and the state it reports on each video. Videos
+-- uploaded before there was a queue were processed on upload, so those with
+-- a file are ready.
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	input_path TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+ALTER TABLE videos ADD COLUMN status TEXT NOT NULL DEFAULT 'draft';
+ALTER TABLE videos ADD COLUMN status_error TEXT;
+UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE videos DROP COLUMN dash_url;
+ALTER TABLE videos DROP COLUMN hls_url;
@@ -0,0 +1,3 @@
+-- HLS and DASH manifests produced alongside the MP4.
+ALTER TABLE videos ADD COLUMN hls_url TEXT;
+ALTER TABLE videos ADD COLUMN dash_url TEXT;
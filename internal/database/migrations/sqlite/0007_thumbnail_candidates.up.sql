@@ -0,0 +1,9 @@
+-- Frames extracted from each video for the owner to pick a thumbnail from.
+CREATE TABLE thumbnail_candidates (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	position_seconds REAL NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+-- What ffprobe reports about the processed video.
+ALTER TABLE videos ADD COLUMN duration_seconds REAL;
+ALTER TABLE videos ADD COLUMN width INTEGER;
+ALTER TABLE videos ADD COLUMN height INTEGER;
+ALTER TABLE videos ADD COLUMN frame_rate REAL;
+ALTER TABLE videos ADD COLUMN video_codec TEXT;
+ALTER TABLE videos ADD COLUMN audio_codec TEXT;
+ALTER TABLE videos ADD COLUMN bit_rate INTEGER;
+ALTER TABLE videos ADD COLUMN rotation INTEGER;
+ALTER TABLE videos ADD COLUMN file_size INTEGER;
@@ -0,0 +1,2 @@
+-- The resized and re-encoded copies of the thumbnail, as JSON.
+ALTER TABLE videos ADD COLUMN thumbnail_variants TEXT;
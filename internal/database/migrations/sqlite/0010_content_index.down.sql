@@ -0,0 +1,3 @@
+ALTER TABLE jobs DROP COLUMN input_sha256;
+ALTER TABLE videos DROP COLUMN content_sha256;
+DROP TABLE content_index;
@@ -0,0 +1,15 @@
+-- Processed outputs shared by every video uploaded with the same bytes,
+-- keyed by the SHA-256 of the upload.
+CREATE TABLE content_index (
+	sha256 TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_key TEXT NOT NULL,
+	hls_key TEXT,
+	dash_key TEXT,
+	file_size INTEGER NOT NULL,
+	ref_count INTEGER NOT NULL
+);
+
+ALTER TABLE videos ADD COLUMN content_sha256 TEXT;
+ALTER TABLE jobs ADD COLUMN input_sha256 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE content_index DROP COLUMN video_sha256;
+ALTER TABLE videos DROP COLUMN video_sha256;
@@ -0,0 +1,3 @@
+-- The SHA-256 of the processed MP4, checked against the stored object.
+ALTER TABLE videos ADD COLUMN video_sha256 TEXT;
+ALTER TABLE content_index ADD COLUMN video_sha256 TEXT;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS videos_user_duration;
+DROP INDEX IF EXISTS videos_user_title;
+DROP INDEX IF EXISTS videos_user_updated_at;
+DROP INDEX IF EXISTS videos_user_created_at;
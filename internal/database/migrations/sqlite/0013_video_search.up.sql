@@ -0,0 +1,33 @@
+-- requires: fts5
+-- Full-text index over video titles and descriptions for SearchVideos: an
+-- FTS5 table with its own copy of each title and description, keyed by
+-- video_id rather than by the videos rowid, which VACUUM is free to
+-- renumber. A build without FTS5 leaves this migration pending and searches
+-- with LIKE instead.
+
+CREATE VIRTUAL TABLE videos_search USING fts5(
+	video_id UNINDEXED,
+	title,
+	description,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO videos_search (video_id, title, description)
+SELECT id, title, COALESCE(description, '') FROM videos;
+
+CREATE TRIGGER videos_search_insert AFTER INSERT ON videos BEGIN
+	INSERT INTO videos_search (video_id, title, description)
+	VALUES (new.id, new.title, COALESCE(new.description, ''));
+END;
+
+-- UpdateVideo writes every column, so only reindex when the text changed.
+CREATE TRIGGER videos_search_update AFTER UPDATE OF title, description ON videos
+WHEN old.title IS NOT new.title OR old.description IS NOT new.description BEGIN
+	UPDATE videos_search
+	SET title = new.title, description = COALESCE(new.description, '')
+	WHERE video_id = old.id;
+END;
+
+CREATE TRIGGER videos_search_delete AFTER DELETE ON videos BEGIN
+	DELETE FROM videos_search WHERE video_id = old.id;
+END;
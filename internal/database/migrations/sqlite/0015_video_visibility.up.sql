@@ -0,0 +1,3 @@
+-- Public videos can be found by everyone through search; the rest only by
+-- their owner.
+ALTER TABLE videos ADD COLUMN public BOOLEAN NOT NULL DEFAULT FALSE;
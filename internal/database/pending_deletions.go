@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PendingDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Attempts  int       `json:"attempts"`
+	LastError *string   `json:"last_error"`
+	// NextAttemptAt is when a failed deletion is next tried, nil if due.
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	BlobRef
+}
+
+type BlobRef struct {
+	Store string `json:"store"`
+	Key   string `json:"key"`
+}
+
+// DeleteVideoWithBlobs removes the video row and, in the same transaction,
+// records every blob it referenced as pending deletion. contentBlobs are the
+// processed outputs, which may be shared with other videos through the
+// content index; they are only included once nothing else references them.
+// The caller is then responsible for deleting the blobs and clearing the
+// records.
+func (c Client) DeleteVideoWithBlobs(id uuid.UUID, blobs []BlobRef, contentBlobs []BlobRef) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var contentSHA256 *string
+	err = tx.QueryRow(`SELECT content_sha256 FROM videos WHERE id = ?`, id).Scan(&contentSHA256)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if contentSHA256 != nil {
+		unused, err := releaseContent(tx, *contentSHA256)
+		if err != nil {
+			return nil, err
+		}
+		if !unused {
+			contentBlobs = nil
+		}
+	}
+
+	pending, err := insertPendingDeletions(tx, append(blobs, contentBlobs...))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM thumbnail_candidates WHERE video_id = ?`, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM videos WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func insertPendingDeletions(tx *sqlTx, blobs []BlobRef) ([]PendingDeletion, error) {
+	query := `
+	INSERT INTO pending_deletions (
+		id,
+		created_at,
+		updated_at,
+		store,
+		object_key,
+		attempts
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 0)
+	`
+	pending := []PendingDeletion{}
+	for _, blob := range blobs {
+		deletionID := uuid.New()
+		if _, err := tx.Exec(query, deletionID, blob.Store, blob.Key); err != nil {
+			return nil, err
+		}
+		pending = append(pending, PendingDeletion{
+			ID:      deletionID,
+			BlobRef: blob,
+		})
+	}
+	return pending, nil
+}
+
+// GetPendingDeletions returns deletions that are due at now and have failed
+// fewer than maxAttempts times. Ones that used up their attempts are kept for
+// an operator to look at.
+func (c Client) GetPendingDeletions(limit, maxAttempts int, now time.Time) ([]PendingDeletion, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		store,
+		object_key,
+		attempts,
+		last_error,
+		next_attempt_at
+	FROM pending_deletions
+	WHERE attempts < ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+	ORDER BY updated_at ASC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, maxAttempts, c.db.dialect.timestamp(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []PendingDeletion{}
+	for rows.Next() {
+		var deletion PendingDeletion
+		if err := rows.Scan(
+			&deletion.ID,
+			&deletion.CreatedAt,
+			&deletion.UpdatedAt,
+			&deletion.Store,
+			&deletion.Key,
+			&deletion.Attempts,
+			&deletion.LastError,
+			&deletion.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+
+	return deletions, rows.Err()
+}
+
+func (c Client) RecordPendingDeletionFailure(id uuid.UUID, deletionErr error, nextAttemptAt time.Time) error {
+	query := `
+	UPDATE pending_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		next_attempt_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, deletionErr.Error(), c.db.dialect.timestamp(nextAttemptAt), id)
+	return err
+}
+
+func (c Client) DeletePendingDeletion(id uuid.UUID) error {
+	query := `
+	DELETE FROM pending_deletions
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateThumbnailCandidateParams
+}
+
+type CreateThumbnailCandidateParams struct {
+	VideoID         uuid.UUID `json:"video_id"`
+	URL             string    `json:"url"`
+	PositionSeconds float64   `json:"position_seconds"`
+}
+
+// ReplaceThumbnailCandidates swaps the candidate set of a video for a new
+// one and returns the candidates it replaced so their files can be removed.
+func (c Client) ReplaceThumbnailCandidates(videoID uuid.UUID, params []CreateThumbnailCandidateParams) ([]ThumbnailCandidate, error) {
+	previous, err := c.GetThumbnailCandidates(videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM thumbnail_candidates WHERE video_id = ?`, videoID); err != nil {
+		return nil, err
+	}
+
+	query := `
+	INSERT INTO thumbnail_candidates (
+		id,
+		created_at,
+		video_id,
+		url,
+		position_seconds
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	for _, candidate := range params {
+		_, err := tx.Exec(query, uuid.New(), videoID, candidate.URL, candidate.PositionSeconds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		url,
+		position_seconds
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY position_seconds ASC
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		var candidate ThumbnailCandidate
+		if err := rows.Scan(
+			&candidate.ID,
+			&candidate.CreatedAt,
+			&candidate.VideoID,
+			&candidate.URL,
+			&candidate.PositionSeconds,
+		); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		url,
+		position_seconds
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+
+	var candidate ThumbnailCandidate
+	err := c.db.QueryRow(query, id).Scan(
+		&candidate.ID,
+		&candidate.CreatedAt,
+		&candidate.VideoID,
+		&candidate.URL,
+		&candidate.PositionSeconds,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ThumbnailCandidate{}, nil
+		}
+		return ThumbnailCandidate{}, err
+	}
+	return candidate, nil
+}
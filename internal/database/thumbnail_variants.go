@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ThumbnailVariant is one resized encoding of a video's thumbnail, enough
+// for a client to build a srcset.
+type ThumbnailVariant struct {
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+}
+
+// ThumbnailVariants is stored as a JSON array in videos.thumbnail_variants;
+// they are always read and replaced together with the video.
+type ThumbnailVariants []ThumbnailVariant
+
+func (v ThumbnailVariants) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (v *ThumbnailVariants) Scan(src any) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		*v = ThumbnailVariants{}
+		return nil
+	case string:
+		data = []byte(src)
+	case []byte:
+		data = src
+	default:
+		return fmt.Errorf("can't scan %T into ThumbnailVariants", src)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID  uuid.UUID `json:"video_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Length   int64     `json:"length"`
+	Metadata string    `json:"metadata"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		metadata
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.Length, params.Metadata)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+const uploadColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		upload_length,
+		upload_offset,
+		metadata
+`
+
+func scanUpload(row rowScanner) (Upload, error) {
+	var upload Upload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Metadata,
+	)
+	return upload, err
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	WHERE id = ?
+	`
+
+	upload, err := scanUpload(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, nil
+		}
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// GetUploadsUpdatedBefore returns the uploads that last received data, or
+// were created, before cutoff.
+func (c Client) GetUploadsUpdatedBefore(cutoff time.Time) ([]Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	WHERE updated_at < ?
+	`
+
+	rows, err := c.db.Query(query, c.db.dialect.timestamp(cutoff))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) UpdateUploadOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, id)
+	return err
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
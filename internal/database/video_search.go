@@ -0,0 +1,279 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// SearchMatchStart and SearchMatchEnd surround the matched words in search
+// highlights. They're control characters rather than markup so callers can
+// escape the text before turning them into whatever highlighting they use.
+const (
+	SearchMatchStart = "\x02"
+	SearchMatchEnd   = "\x03"
+)
+
+var ErrEmptySearch = errors.New("search has no words to match")
+
+type SearchScope string
+
+const (
+	// SearchScopeOwn searches the caller's videos, public or not.
+	SearchScopeOwn SearchScope = "own"
+	// SearchScopePublic searches everyone's public videos.
+	SearchScopePublic SearchScope = "public"
+)
+
+type SearchVideosParams struct {
+	// UserID is the caller, whose videos SearchScopeOwn covers.
+	UserID uuid.UUID
+	Scope  SearchScope
+	// Query is matched word by word, each as a prefix, and a video has to
+	// match all of them. Anything but letters and digits separates words.
+	Query string
+	Limit int
+	// After continues from the end of a previous page of the same search.
+	After *VideoCursor
+}
+
+type VideoSearchHit struct {
+	Video
+	// TitleHighlight is the whole title and DescriptionHighlight the best
+	// matching stretch of the description, with matches marked by
+	// SearchMatchStart and SearchMatchEnd.
+	TitleHighlight       string
+	DescriptionHighlight string
+	score                float64
+}
+
+type VideoSearchPage struct {
+	Hits []VideoSearchHit
+	// Next is nil on the last page.
+	Next *VideoCursor
+}
+
+// SearchVideos finds videos in the scope whose title or description match
+// the query, best first. Title matches weigh more than description matches.
+// Pages continue from a cursor like ListVideos, ordered by a score where
+// lower is better, then by ID.
+func (c Client) SearchVideos(params SearchVideosParams) (VideoSearchPage, error) {
+	terms := searchTerms(params.Query)
+	if len(terms) == 0 {
+		return VideoSearchPage{}, ErrEmptySearch
+	}
+	search := strings.ToLower(strings.Join(terms, " "))
+
+	var scope string
+	var scopeArgs []any
+	switch params.Scope {
+	case SearchScopeOwn:
+		scope = "videos.user_id = ?"
+		scopeArgs = []any{params.UserID}
+	case SearchScopePublic:
+		scope = "videos.public"
+	default:
+		return VideoSearchPage{}, fmt.Errorf("unknown search scope %q", params.Scope)
+	}
+
+	// Each engine produces every video column plus title_highlight,
+	// description_highlight and score.
+	var hits string
+	var args []any
+	switch {
+	case c.db.dialect == dialectPostgres:
+		prefixes := make([]string, len(terms))
+		for i, term := range terms {
+			prefixes[i] = term + ":*"
+		}
+		hits = `
+		SELECT
+			videos.*,
+			ts_headline('simple', title, search_query, 'HighlightAll=true, StartSel=' || chr(2) || ', StopSel=' || chr(3)) AS title_highlight,
+			ts_headline('simple', COALESCE(description, ''), search_query, 'MinWords=12, MaxWords=24, StartSel=' || chr(2) || ', StopSel=' || chr(3)) AS description_highlight,
+			-ts_rank(search_vector, search_query)::DOUBLE PRECISION AS score
+		FROM videos, to_tsquery('simple', ?) AS search_query
+		WHERE search_vector @@ search_query AND ` + scope
+		args = append([]any{strings.Join(prefixes, " & ")}, scopeArgs...)
+	case c.fts5:
+		prefixes := make([]string, len(terms))
+		for i, term := range terms {
+			prefixes[i] = `"` + term + `"*`
+		}
+		// The columns of videos_search are video_id, title and description;
+		// bm25 takes a weight and highlight and snippet an index for each.
+		hits = `
+		SELECT
+			videos.*,
+			highlight(videos_search, 1, char(2), char(3)) AS title_highlight,
+			snippet(videos_search, 2, char(2), char(3), '…', 24) AS description_highlight,
+			bm25(videos_search, 0.0, 10.0, 1.0) AS score
+		FROM videos_search
+		JOIN videos ON videos.id = videos_search.video_id
+		WHERE videos_search MATCH ? AND ` + scope
+		args = append([]any{strings.Join(prefixes, " ")}, scopeArgs...)
+	default:
+		// Without FTS5, each word has to appear somewhere in the title or
+		// description, and videos matching more of them in the title rank
+		// higher. SearchVideos highlights these itself.
+		titleMatches := make([]string, len(terms))
+		conditions := make([]string, len(terms))
+		var conditionArgs []any
+		for i, term := range terms {
+			pattern := "%" + term + "%"
+			titleMatches[i] = "(title LIKE ?)"
+			args = append(args, pattern)
+			conditions[i] = "(title LIKE ? OR COALESCE(description, '') LIKE ?)"
+			conditionArgs = append(conditionArgs, pattern, pattern)
+		}
+		hits = `
+		SELECT
+			videos.*,
+			title AS title_highlight,
+			COALESCE(description, '') AS description_highlight,
+			-(` + strings.Join(titleMatches, " + ") + `) AS score
+		FROM videos
+		WHERE ` + strings.Join(conditions, " AND ") + ` AND ` + scope
+		args = append(append(args, conditionArgs...), scopeArgs...)
+	}
+
+	query := `
+	SELECT` + videoColumns + `, title_highlight, description_highlight, score
+	FROM (` + hits + `) AS hits
+	`
+	if params.After != nil {
+		after := params.After
+		if after.Sort != VideoSortRelevance || after.Search != search || after.Scope != params.Scope {
+			return VideoSearchPage{}, ErrInvalidCursor
+		}
+		query += `WHERE (score, id) > (?, ?)
+		`
+		args = append(args, after.Score, after.ID)
+	}
+	// One extra row tells whether another page follows.
+	query += `ORDER BY score, id
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return VideoSearchPage{}, err
+	}
+	defer rows.Close()
+
+	page := VideoSearchPage{Hits: []VideoSearchHit{}}
+	for rows.Next() {
+		var hit VideoSearchHit
+		hit.Video, err = scanVideo(searchHitScanner{rows, &hit})
+		if err != nil {
+			return VideoSearchPage{}, err
+		}
+		if c.db.dialect == dialectSQLite && !c.fts5 {
+			hit.TitleHighlight = highlightTerms(hit.TitleHighlight, terms, 0)
+			hit.DescriptionHighlight = highlightTerms(hit.DescriptionHighlight, terms, searchSnippetRunes)
+		}
+		page.Hits = append(page.Hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoSearchPage{}, err
+	}
+
+	if len(page.Hits) > params.Limit {
+		page.Hits = page.Hits[:params.Limit]
+		last := page.Hits[len(page.Hits)-1]
+		page.Next = &VideoCursor{
+			Sort:   VideoSortRelevance,
+			Score:  last.score,
+			Search: search,
+			Scope:  params.Scope,
+			ID:     last.ID,
+		}
+	}
+	return page, nil
+}
+
+// searchHitScanner lets scanVideo read a search row, picking up the
+// highlight and score columns that follow the video's.
+type searchHitScanner struct {
+	row rowScanner
+	hit *VideoSearchHit
+}
+
+func (s searchHitScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, &s.hit.TitleHighlight, &s.hit.DescriptionHighlight, &s.hit.score)...)
+}
+
+// searchTerms splits a query into words, dropping everything else so that
+// neither FTS5, tsquery nor LIKE syntax can leak in from user input.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+}
+
+// searchSnippetRunes is about the length of the description snippets FTS5
+// makes, for the LIKE fallback to match.
+const searchSnippetRunes = 160
+
+// highlightTerms marks every case-insensitive occurrence of the terms in
+// text. With a non-zero maxRunes, longer text is cut down to a window that
+// starts a little before the first match.
+func highlightTerms(text string, terms []string, maxRunes int) string {
+	sorted := append([]string{}, terms...)
+	// Longest first, so a term that starts another doesn't cut its match
+	// short.
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	quoted := make([]string, len(sorted))
+	for i, term := range sorted {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	pattern := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	if maxRunes > 0 && utf8.RuneCountInString(text) > maxRunes {
+		text = snippetAround(text, pattern.FindStringIndex(text), maxRunes)
+	}
+	return pattern.ReplaceAllString(text, SearchMatchStart+"$0"+SearchMatchEnd)
+}
+
+// snippetAround cuts text to about maxRunes runes at word boundaries,
+// keeping the match in view, and marks the cuts with an ellipsis.
+func snippetAround(text string, match []int, maxRunes int) string {
+	runes := []rune(text)
+	start := 0
+	if match != nil {
+		start = max(utf8.RuneCountInString(text[:match[0]])-maxRunes/4, 0)
+	}
+	start = min(start, len(runes)-maxRunes)
+	end := start + maxRunes
+
+	// Move inwards to the nearest spaces so no word is cut in half, unless
+	// the window is all one word.
+	wordStart, wordEnd := start, end
+	for wordStart > 0 && wordStart < wordEnd && !unicode.IsSpace(runes[wordStart-1]) {
+		wordStart++
+	}
+	for wordEnd < len(runes) && wordEnd > wordStart && !unicode.IsSpace(runes[wordEnd]) {
+		wordEnd--
+	}
+	if wordStart < wordEnd {
+		start, end = wordStart, wordEnd
+	}
+
+	snippet := strings.TrimSpace(string(runes[start:end]))
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
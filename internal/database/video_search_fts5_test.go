@@ -0,0 +1,81 @@
+//go:build sqlite_fts5
+
+package database
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// TestSearchVideosFTS5 covers what only the FTS5 index does: ranking by
+// bm25 and cutting description snippets around the match.
+func TestSearchVideosFTS5(t *testing.T) {
+	c := newTestClient(t)
+	if !c.fts5 {
+		t.Fatal("built with sqlite_fts5 but SQLite has no FTS5")
+	}
+	user, err := c.CreateUser(CreateUserParams{Email: "baker@example.com", Password: "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(title, description string) Video {
+		t.Helper()
+		video, err := c.CreateVideo(CreateVideoParams{Title: title, Description: description, Public: true, UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return video
+	}
+
+	long := strings.Repeat("Flour, water and time. ", 12) + "Feeding the sourdough starter every morning. " + strings.Repeat("Then the oven. ", 12)
+	inTitle := create("Sourdough basics", "Getting started")
+	inDescriptionTwice := create("Weekend bread", "Sourdough loaves from a sourdough starter")
+	inDescriptionOnce := create("Kitchen diary", long)
+	// bm25 only ranks terms most videos don't contain.
+	for i := 0; i < 10; i++ {
+		create("Pasta night", "Nothing to do with bread")
+	}
+
+	page, err := c.SearchVideos(SearchVideosParams{Scope: SearchScopePublic, Query: "sourdough", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []uuid.UUID{}
+	for _, hit := range page.Hits {
+		got = append(got, hit.ID)
+	}
+	want := []uuid.UUID{inTitle.ID, inDescriptionTwice.ID, inDescriptionOnce.ID}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("ranked %v, want title match, then two description matches, then one: %v", got, want)
+	}
+
+	if highlight := page.Hits[0].TitleHighlight; highlight != SearchMatchStart+"Sourdough"+SearchMatchEnd+" basics" {
+		t.Errorf("title highlight = %q", highlight)
+	}
+	snippet := page.Hits[2].DescriptionHighlight
+	if !strings.Contains(snippet, SearchMatchStart+"sourdough"+SearchMatchEnd) {
+		t.Errorf("description snippet %q doesn't mark the match", snippet)
+	}
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") || utf8.RuneCountInString(snippet) >= utf8.RuneCountInString(long) {
+		t.Errorf("description snippet %q isn't cut down around the match", snippet)
+	}
+
+	// The index follows edits and deletions through its triggers.
+	inTitle.Title = "Rye basics"
+	if err := c.UpdateVideo(inTitle); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteVideo(inDescriptionTwice.ID); err != nil {
+		t.Fatal(err)
+	}
+	page, err = c.SearchVideos(SearchVideosParams{Scope: SearchScopePublic, Query: "sourdough", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Hits) != 1 || page.Hits[0].ID != inDescriptionOnce.ID {
+		t.Errorf("after editing and deleting, search found %d videos", len(page.Hits))
+	}
+}
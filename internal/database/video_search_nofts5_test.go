@@ -0,0 +1,40 @@
+//go:build !sqlite_fts5
+
+package database
+
+import (
+	"testing"
+)
+
+// TestVideoSearchWithoutFTS5 checks that a build without FTS5 leaves the
+// search index to a build that has it, and won't open a database where one
+// already created it.
+func TestVideoSearchWithoutFTS5(t *testing.T) {
+	dsn := newTestDSN(t, dialectSQLite)
+	c := newTestClientAt(t, dsn)
+	if c.FullTextSearch() {
+		t.Skip("SQLite has FTS5 without the sqlite_fts5 tag")
+	}
+
+	states, err := c.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var search *MigrationState
+	for i, state := range states {
+		if state.needsFTS5 {
+			search = &states[i]
+		}
+	}
+	if search == nil || search.AppliedAt != nil {
+		t.Fatalf("search migration = %+v, want it pending", search)
+	}
+
+	if _, err := c.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, search.Version, search.Name); err != nil {
+		t.Fatal(err)
+	}
+	if reopened, err := NewClient(dsn); err == nil {
+		reopened.db.Close()
+		t.Error("opened a database indexed with FTS5 without FTS5")
+	}
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"go concurrency", []string{"go", "concurrency"}},
+		{`"boot*" NEAR(x) OR -y`, []string{"boot", "NEAR", "x", "OR", "y"}},
+		{"café, 100%", []string{"café", "100"}},
+		{"title:foo & bar:*", []string{"title", "foo", "bar"}},
+		{"  ", []string{}},
+	}
+	for _, tt := range tests {
+		got := searchTerms(tt.query)
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightTerms(t *testing.T) {
+	mark := func(s string) string {
+		return strings.NewReplacer("[", SearchMatchStart, "]", SearchMatchEnd).Replace(s)
+	}
+	tests := []struct {
+		text     string
+		terms    []string
+		maxRunes int
+		want     string
+	}{
+		{"Boot.dev tour", []string{"boot"}, 0, "[Boot].dev tour"},
+		{"coffee and COFFEE", []string{"coff"}, 0, "[coff]ee and [COFF]EE"},
+		{"cook cookbook", []string{"cook", "cookbook"}, 0, "[cook] [cookbook]"},
+		{"no match here", []string{"zebra"}, 0, "no match here"},
+		{"one two three four five six seven", []string{"six"}, 15, "…five [six] seven"},
+		{"one two three four five six seven", []string{"one"}, 12, "[one] two…"},
+		{"one two three four five six seven", []string{"seven"}, 12, "…six [seven]"},
+		{"abcdefghijklmnop", []string{"ghi"}, 8, "…ef[ghi]jkl…"},
+	}
+	for _, tt := range tests {
+		got := highlightTerms(tt.text, tt.terms, tt.maxRunes)
+		if got != mark(tt.want) {
+			t.Errorf("highlightTerms(%q, %q, %d) = %q, want %q", tt.text, tt.terms, tt.maxRunes, got, mark(tt.want))
+		}
+	}
+}
@@ -2,44 +2,351 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploaded   VideoStatus = "uploaded"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                uuid.UUID         `json:"id"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+	ThumbnailURL      *string           `json:"thumbnail_url"`
+	ThumbnailVariants ThumbnailVariants `json:"thumbnail_variants"`
+	VideoURL          *string           `json:"video_url"`
+	HLSURL            *string           `json:"hls_url"`
+	DASHURL           *string           `json:"dash_url"`
+	Status            VideoStatus       `json:"status"`
+	StatusError       *string           `json:"status_error"`
+	Metadata          VideoMetadata     `json:"metadata"`
+	// ContentSHA256 is the hash of the upload the outputs were produced
+	// from, the key of its content_index entry.
+	ContentSHA256 *string `json:"content_sha256"`
+	// VideoSHA256 is the hash of the stored MP4 at VideoURL, checked by the
+	// store on upload and kept so the bucket can be audited later.
+	VideoSHA256 *string `json:"video_sha256"`
 	CreateVideoParams
 }
 
+// VideoMetadata is what ffprobe reported about the stored video. Width and
+// height are the displayed size, i.e. after applying Rotation.
+type VideoMetadata struct {
+	DurationSeconds *float64 `json:"duration_seconds"`
+	Width           *int     `json:"width"`
+	Height          *int     `json:"height"`
+	FrameRate       *float64 `json:"frame_rate"`
+	VideoCodec      *string  `json:"video_codec"`
+	AudioCodec      *string  `json:"audio_codec"`
+	BitRate         *int64   `json:"bit_rate"`
+	Rotation        *int     `json:"rotation"`
+	FileSize        *int64   `json:"file_size"`
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Public videos show up in everyone's searches.
+	Public bool      `json:"public"`
+	UserID uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_variants,
 		video_url,
+		hls_url,
+		dash_url,
+		status,
+		status_error,
+		duration_seconds,
+		width,
+		height,
+		frame_rate,
+		video_codec,
+		audio_codec,
+		bit_rate,
+		rotation,
+		file_size,
+		content_sha256,
+		video_sha256,
+		public,
 		user_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailVariants,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		&video.Status,
+		&video.StatusError,
+		&video.Metadata.DurationSeconds,
+		&video.Metadata.Width,
+		&video.Metadata.Height,
+		&video.Metadata.FrameRate,
+		&video.Metadata.VideoCodec,
+		&video.Metadata.AudioCodec,
+		&video.Metadata.BitRate,
+		&video.Metadata.Rotation,
+		&video.Metadata.FileSize,
+		&video.ContentSHA256,
+		&video.VideoSHA256,
+		&video.Public,
+		&video.UserID,
+	)
+	return video, err
+}
+
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortUpdatedAt VideoSort = "updated_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortDuration  VideoSort = "duration"
+	// VideoSortRelevance orders search results, best match first. Listings
+	// can't use it.
+	VideoSortRelevance VideoSort = "relevance"
+)
+
+// videoSortKeys are what each sort orders by. Videos without metadata sort
+// as zero-length so the key is never NULL, which keyset comparisons need.
+var videoSortKeys = map[VideoSort]string{
+	VideoSortCreatedAt: "created_at",
+	VideoSortUpdatedAt: "updated_at",
+	VideoSortTitle:     "title",
+	VideoSortDuration:  "COALESCE(duration_seconds, 0)",
+}
+
+type Orientation string
+
+const (
+	OrientationLandscape Orientation = "landscape"
+	OrientationPortrait  Orientation = "portrait"
+	OrientationSquare    Orientation = "square"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListVideosParams struct {
+	UserID     uuid.UUID
+	Limit      int
+	Sort       VideoSort
+	Descending bool
+	// After continues the listing from the end of a previous page, which
+	// must have used the same sort.
+	After *VideoCursor
+	// Nil filters match everything. Orientation is taken from the probed
+	// dimensions, so it never matches videos without metadata.
+	HasVideo      *bool
+	HasThumbnail  *bool
+	Orientation   Orientation
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+type VideoPage struct {
+	Videos []Video
+	// Next is nil on the last page.
+	Next *VideoCursor
+}
+
+// VideoCursor is the position of the last video on a page: its sort key,
+// with the ID to break ties. Search cursors also record the search they
+// came from, since scores only compare within one.
+type VideoCursor struct {
+	Sort       VideoSort   `json:"s"`
+	Descending bool        `json:"d,omitempty"`
+	Time       *time.Time  `json:"t,omitempty"`
+	Title      string      `json:"n,omitempty"`
+	Duration   float64     `json:"v,omitempty"`
+	Score      float64     `json:"r,omitempty"`
+	Search     string      `json:"q,omitempty"`
+	Scope      SearchScope `json:"c,omitempty"`
+	ID         uuid.UUID   `json:"id"`
+}
+
+// Encode returns the cursor as an opaque token for clients.
+func (vc VideoCursor) Encode() string {
+	data, _ := json.Marshal(vc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeVideoCursor(token string) (VideoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	var vc VideoCursor
+	if err := json.Unmarshal(data, &vc); err != nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	if _, ok := videoSortKeys[vc.Sort]; (!ok && vc.Sort != VideoSortRelevance) || vc.ID == uuid.Nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	if (vc.Sort == VideoSortCreatedAt || vc.Sort == VideoSortUpdatedAt) && vc.Time == nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	return vc, nil
+}
+
+func videoCursorFor(video Video, sort VideoSort, descending bool) *VideoCursor {
+	vc := &VideoCursor{Sort: sort, Descending: descending, ID: video.ID}
+	switch sort {
+	case VideoSortCreatedAt:
+		vc.Time = &video.CreatedAt
+	case VideoSortUpdatedAt:
+		vc.Time = &video.UpdatedAt
+	case VideoSortTitle:
+		vc.Title = video.Title
+	case VideoSortDuration:
+		if video.Metadata.DurationSeconds != nil {
+			vc.Duration = *video.Metadata.DurationSeconds
+		}
+	}
+	return vc
+}
+
+func (c Client) videoCursorKey(vc VideoCursor) any {
+	switch vc.Sort {
+	case VideoSortCreatedAt, VideoSortUpdatedAt:
+		return c.db.dialect.timestamp(*vc.Time)
+	case VideoSortTitle:
+		return vc.Title
+	}
+	return vc.Duration
+}
+
+// ListVideos returns one page of the user's videos. Pages are found by
+// seeking past the cursor's sort key rather than with OFFSET, so each costs
+// the same however deep the listing goes.
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	sortKey, ok := videoSortKeys[params.Sort]
+	if !ok {
+		return VideoPage{}, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+	direction, comparison := "ASC", ">"
+	if params.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := []string{"user_id = ?"}
+	args := []any{params.UserID}
+	if params.HasVideo != nil {
+		if *params.HasVideo {
+			conditions = append(conditions, "video_url IS NOT NULL")
+		} else {
+			conditions = append(conditions, "video_url IS NULL")
+		}
+	}
+	if params.HasThumbnail != nil {
+		if *params.HasThumbnail {
+			conditions = append(conditions, "thumbnail_url IS NOT NULL")
+		} else {
+			conditions = append(conditions, "thumbnail_url IS NULL")
+		}
+	}
+	switch params.Orientation {
+	case "":
+	case OrientationLandscape:
+		conditions = append(conditions, "width > height")
+	case OrientationPortrait:
+		conditions = append(conditions, "height > width")
+	case OrientationSquare:
+		conditions = append(conditions, "width = height")
+	default:
+		return VideoPage{}, fmt.Errorf("unknown orientation %q", params.Orientation)
+	}
+	if params.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, c.db.dialect.timestamp(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, c.db.dialect.timestamp(*params.CreatedBefore))
+	}
+	if params.After != nil {
+		if params.After.Sort != params.Sort || params.After.Descending != params.Descending {
+			return VideoPage{}, ErrInvalidCursor
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (?, ?)", sortKey, comparison))
+		args = append(args, c.videoCursorKey(*params.After), params.After.ID)
+	}
+
+	// One extra row tells whether another page follows.
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + sortKey + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
 	`
+	args = append(args, params.Limit+1)
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	page := VideoPage{Videos: []Video{}}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		page.Videos = append(page.Videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoPage{}, err
+	}
+
+	if len(page.Videos) > params.Limit {
+		page.Videos = page.Videos[:params.Limit]
+		page.Next = videoCursorFor(page.Videos[len(page.Videos)-1], params.Sort, params.Descending)
+	}
+	return page, nil
+}
+
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
+
+	rows, err := c.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +354,14 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +373,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		status,
+		public,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, VideoStatusDraft, params.Public, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +388,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -128,8 +411,23 @@ func (c Client) UpdateVideo(video Video) error {
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_variants = ?,
 		video_url = ?,
-		user_id = ?
+		hls_url = ?,
+		dash_url = ?,
+		duration_seconds = ?,
+		width = ?,
+		height = ?,
+		frame_rate = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		bit_rate = ?,
+		rotation = ?,
+		file_size = ?,
+		content_sha256 = ?,
+		video_sha256 = ?,
+		user_id = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
@@ -138,13 +436,171 @@ func (c Client) UpdateVideo(video Video) error {
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		&video.ThumbnailVariants,
 		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		video.Metadata.DurationSeconds,
+		video.Metadata.Width,
+		video.Metadata.Height,
+		video.Metadata.FrameRate,
+		video.Metadata.VideoCodec,
+		video.Metadata.AudioCodec,
+		video.Metadata.BitRate,
+		video.Metadata.Rotation,
+		video.Metadata.FileSize,
+		video.ContentSHA256,
+		video.VideoSHA256,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+// ReplaceVideoOutputs writes only what processing produces: the stored
+// outputs, their content entry and the probed metadata. Title, description
+// and thumbnail may be edited while a job runs and are left alone.
+//
+// The caller holds a reference on the new content entry. In the same
+// transaction the reference of the entry the row pointed at until now is
+// dropped, and if that was the last one, contentRefs of the replaced outputs
+// are recorded as pending deletion. The row is locked before it's read, so
+// two jobs for the same video each release what they actually replaced.
+func (c Client) ReplaceVideoOutputs(video Video, contentRefs func(Video) []BlobRef) ([]PendingDeletion, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// A write rather than a SELECT so SQLite takes its write lock up front,
+	// where Postgres would need FOR UPDATE.
+	var previous Video
+	err = tx.QueryRow(`
+	UPDATE videos
+	SET updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	RETURNING video_url, hls_url, dash_url, content_sha256
+	`, video.ID).Scan(&previous.VideoURL, &previous.HLSURL, &previous.DASHURL, &previous.ContentSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		hls_url = ?,
+		dash_url = ?,
+		duration_seconds = ?,
+		width = ?,
+		height = ?,
+		frame_rate = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		bit_rate = ?,
+		rotation = ?,
+		file_size = ?,
+		content_sha256 = ?,
+		video_sha256 = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+
+	_, err = tx.Exec(
+		query,
+		&video.VideoURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		video.Metadata.DurationSeconds,
+		video.Metadata.Width,
+		video.Metadata.Height,
+		video.Metadata.FrameRate,
+		video.Metadata.VideoCodec,
+		video.Metadata.AudioCodec,
+		video.Metadata.BitRate,
+		video.Metadata.Rotation,
+		video.Metadata.FileSize,
+		video.ContentSHA256,
+		video.VideoSHA256,
+		video.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []PendingDeletion{}
+	if previous.ContentSHA256 != nil {
+		unused, err := releaseContent(tx, *previous.ContentSHA256)
+		if err != nil {
+			return nil, err
+		}
+		if unused {
+			pending, err = insertPendingDeletions(tx, contentRefs(previous))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// SetVideoThumbnail only touches the thumbnail, so it can't undo outputs a
+// job wrote after the caller read the row.
+func (c Client) SetVideoThumbnail(id uuid.UUID, thumbnailURL string, variants ThumbnailVariants) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_variants = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, thumbnailURL, variants, id)
+	return err
+}
+
+// SetDefaultThumbnail sets a thumbnail only if the video still has none, and
+// reports whether it did. A thumbnail the owner uploads in the meantime wins.
+func (c Client) SetDefaultThumbnail(id uuid.UUID, thumbnailURL string, variants ThumbnailVariants) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_variants = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND thumbnail_url IS NULL
+	`
+	result, err := c.db.Exec(query, thumbnailURL, variants, id)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// UpdateVideoStatus only touches the processing state, so a worker can report
+// progress without overwriting fields the owner edited in the meantime.
+func (c Client) UpdateVideoStatus(id uuid.UUID, status VideoStatus, statusError *string) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		status_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, statusError, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
 	query := `
 	DELETE FROM videos
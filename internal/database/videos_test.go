@@ -0,0 +1,361 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReplaceVideoOutputsKeepsEdits(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		video := newTestVideo(t, c)
+		stale := video
+
+		// The owner edits the video while a job holds the copy it started with.
+		thumbnail := "thumbnails/uploaded.jpg"
+		video.Title = "Edited"
+		video.ThumbnailURL = &thumbnail
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+
+		videoURL := "landscape/out.mp4"
+		sha := "abc123"
+		width := 1920
+		stale.VideoURL = &videoURL
+		stale.ContentSHA256 = &sha
+		stale.Metadata.Width = &width
+		if _, err := c.ReplaceVideoOutputs(stale, contentRefs); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Edited" || got.ThumbnailURL == nil || *got.ThumbnailURL != thumbnail {
+			t.Errorf("edits were overwritten: title %q, thumbnail %v", got.Title, got.ThumbnailURL)
+		}
+		if got.VideoURL == nil || *got.VideoURL != videoURL || got.ContentSHA256 == nil || *got.ContentSHA256 != sha {
+			t.Errorf("outputs weren't written: video %v, content %v", got.VideoURL, got.ContentSHA256)
+		}
+		if got.Metadata.Width == nil || *got.Metadata.Width != width {
+			t.Errorf("metadata wasn't written: width %v", got.Metadata.Width)
+		}
+	})
+}
+
+func TestSetVideoThumbnailKeepsOutputs(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		video := newTestVideo(t, c)
+		stale := video
+
+		// A job writes its outputs while the owner's thumbnail upload holds
+		// the copy it read before.
+		content := registerTestContent(t, c, "abc123")
+		if _, err := c.ReplaceVideoOutputs(withContent(video, content), contentRefs); err != nil {
+			t.Fatal(err)
+		}
+
+		variants := ThumbnailVariants{{URL: "thumbnails/a_320.jpg", Width: 320, Height: 180, ContentType: "image/jpeg"}}
+		if err := c.SetVideoThumbnail(stale.ID, "thumbnails/a.jpg", variants); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.VideoURL == nil || *got.VideoURL != content.VideoKey || got.ContentSHA256 == nil || *got.ContentSHA256 != content.SHA256 {
+			t.Errorf("outputs were overwritten: video %v, content %v", got.VideoURL, got.ContentSHA256)
+		}
+		if got.ThumbnailURL == nil || *got.ThumbnailURL != "thumbnails/a.jpg" {
+			t.Errorf("thumbnail = %v, want thumbnails/a.jpg", got.ThumbnailURL)
+		}
+		if !reflect.DeepEqual(got.ThumbnailVariants, variants) {
+			t.Errorf("variants = %v, want %v", got.ThumbnailVariants, variants)
+		}
+	})
+}
+
+func TestSetDefaultThumbnail(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		video := newTestVideo(t, c)
+
+		variants := ThumbnailVariants{{URL: "thumbnails/a_320.jpg", Width: 320, Height: 180, ContentType: "image/jpeg"}}
+		set, err := c.SetDefaultThumbnail(video.ID, "thumbnails/candidates/a.jpg", variants)
+		if err != nil || !set {
+			t.Fatalf("SetDefaultThumbnail on a video without one = %v, %v", set, err)
+		}
+
+		set, err = c.SetDefaultThumbnail(video.ID, "thumbnails/candidates/b.jpg", nil)
+		if err != nil || set {
+			t.Fatalf("SetDefaultThumbnail on a video with one = %v, %v", set, err)
+		}
+
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ThumbnailURL == nil || *got.ThumbnailURL != "thumbnails/candidates/a.jpg" {
+			t.Errorf("thumbnail = %v, want the first default", got.ThumbnailURL)
+		}
+		if len(got.ThumbnailVariants) != 1 || got.ThumbnailVariants[0] != variants[0] {
+			t.Errorf("variants = %v, want %v", got.ThumbnailVariants, variants)
+		}
+	})
+}
+
+// contentRefs stands in for the server's mapping of a video's outputs to
+// blobs.
+func contentRefs(video Video) []BlobRef {
+	return []BlobRef{{Store: "blob", Key: *video.VideoURL}}
+}
+
+func registerTestContent(t *testing.T, c Client, sha string) ContentObject {
+	t.Helper()
+	content, created, err := c.RegisterContent(ContentObject{SHA256: sha, VideoKey: sha + ".mp4"})
+	if err != nil || !created {
+		t.Fatalf("registering content %s = %v, %v", sha, created, err)
+	}
+	return content
+}
+
+func withContent(video Video, content ContentObject) Video {
+	video.VideoURL = &content.VideoKey
+	video.ContentSHA256 = &content.SHA256
+	return video
+}
+
+func TestReplaceVideoOutputsReleasesWhatItReplaced(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		video := newTestVideo(t, c)
+
+		refCount := func(sha string) int {
+			t.Helper()
+			var count int
+			err := c.db.QueryRow(`SELECT ref_count FROM content_index WHERE sha256 = ?`, sha).Scan(&count)
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			return count
+		}
+
+		first := registerTestContent(t, c, "first")
+		pending, err := c.ReplaceVideoOutputs(withContent(video, first), contentRefs)
+		if err != nil || len(pending) != 0 {
+			t.Fatalf("first outputs = %v, %v; want nothing pending", pending, err)
+		}
+
+		// Two jobs for the same video start from the same copy of the row,
+		// which points at first, and finish one after the other.
+		stale, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second := registerTestContent(t, c, "second")
+		third := registerTestContent(t, c, "third")
+
+		pending, err = c.ReplaceVideoOutputs(withContent(stale, second), contentRefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Key != "first.mp4" {
+			t.Errorf("first job pending = %v, want first.mp4", pending)
+		}
+
+		pending, err = c.ReplaceVideoOutputs(withContent(stale, third), contentRefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Key != "second.mp4" {
+			t.Errorf("second job pending = %v, want second.mp4", pending)
+		}
+
+		for sha, want := range map[string]int{"first": 0, "second": 0, "third": 1} {
+			if got := refCount(sha); got != want {
+				t.Errorf("ref count of %s = %d, want %d", sha, got, want)
+			}
+		}
+
+		// Outputs another video still uses are released but kept.
+		other := newTestVideo(t, c)
+		if _, err := c.AcquireContent("third"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ReplaceVideoOutputs(withContent(other, third), contentRefs); err != nil {
+			t.Fatal(err)
+		}
+		fourth := registerTestContent(t, c, "fourth")
+		pending, err = c.ReplaceVideoOutputs(withContent(stale, fourth), contentRefs)
+		if err != nil || len(pending) != 0 {
+			t.Errorf("replacing shared outputs = %v, %v; want nothing pending", pending, err)
+		}
+		if got := refCount("third"); got != 1 {
+			t.Errorf("ref count of third = %d, want 1", got)
+		}
+
+		all, err := c.GetPendingDeletions(10, 5, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 {
+			t.Errorf("%d pending deletions recorded, want 2", len(all))
+		}
+
+		deleted := stale
+		deleted.ID = uuid.New()
+		if _, err := c.ReplaceVideoOutputs(withContent(deleted, fourth), contentRefs); err == nil {
+			t.Error("ReplaceVideoOutputs succeeded for a deleted video")
+		}
+	})
+}
+
+func TestVideoCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 3, 14, 15, 9, 26, 535000000, time.UTC)
+	id := uuid.MustParse("6f1c2b8e-3d4a-4f5b-9c6d-7e8f9a0b1c2d")
+	tests := []VideoCursor{
+		{Sort: VideoSortCreatedAt, Time: &created, ID: id},
+		{Sort: VideoSortUpdatedAt, Descending: true, Time: &created, ID: id},
+		{Sort: VideoSortTitle, Title: "Café, \"quoted\" & <tagged>", ID: id},
+		{Sort: VideoSortTitle, Descending: true, ID: id},
+		{Sort: VideoSortDuration, Duration: 12.345, ID: id},
+		{Sort: VideoSortRelevance, Score: -3.25, Search: "go concurrency", Scope: SearchScopePublic, ID: id},
+	}
+	for _, want := range tests {
+		token := want.Encode()
+		if _, err := base64.RawURLEncoding.DecodeString(token); err != nil {
+			t.Errorf("token %q isn't unpadded URL-safe base64: %v", token, err)
+		}
+		got, err := DecodeVideoCursor(token)
+		if err != nil {
+			t.Errorf("DecodeVideoCursor(%+v.Encode()) = %v", want, err)
+			continue
+		}
+		if got.Time != nil && want.Time != nil && got.Time.Equal(*want.Time) {
+			got.Time = want.Time
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeVideoCursorRejects(t *testing.T) {
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+	id := `"6f1c2b8e-3d4a-4f5b-9c6d-7e8f9a0b1c2d"`
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"title","n":"a","id":` + id + `}`))},
+		{"not JSON", encode("title," + id)},
+		{"JSON array", encode(`["title",` + id + `]`)},
+		{"unknown sort", encode(`{"s":"views","id":` + id + `}`)},
+		{"no sort", encode(`{"id":` + id + `}`)},
+		{"no ID", encode(`{"s":"title","n":"x"}`)},
+		{"nil ID", encode(`{"s":"title","id":"00000000-0000-0000-0000-000000000000"}`)},
+		{"bad ID", encode(`{"s":"title","id":"42"}`)},
+		{"created_at without time", encode(`{"s":"created_at","id":` + id + `}`)},
+		{"updated_at with a bad time", encode(`{"s":"updated_at","t":"yesterday","id":` + id + `}`)},
+		{"wrong type", encode(`{"s":"duration","v":"long","id":` + id + `}`)},
+	}
+	for _, tt := range tests {
+		if vc, err := DecodeVideoCursor(tt.token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: DecodeVideoCursor(%q) = %+v, %v; want ErrInvalidCursor", tt.name, tt.token, vc, err)
+		}
+	}
+}
+
+// TestListVideosPages walks every sort both ways one page at a time, passing
+// the cursor through its token as clients do, and checks nothing is skipped
+// or repeated.
+func TestListVideosPages(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, dsn string) {
+		c := newTestClientAt(t, dsn)
+		user, err := c.CreateUser(CreateUserParams{Email: "pager@example.com", Password: "unused"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Repeated titles and durations make the ID tie-break matter.
+		titles := []string{"b", "a", "c", "a", "b"}
+		durations := []float64{30, 10, 30, 0, 20}
+		for i, title := range titles {
+			video, err := c.CreateVideo(CreateVideoParams{Title: title, UserID: user.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if durations[i] > 0 {
+				video.Metadata.DurationSeconds = &durations[i]
+				if err := c.UpdateVideo(video); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, sort := range []VideoSort{VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle, VideoSortDuration} {
+			for _, descending := range []bool{false, true} {
+				params := ListVideosParams{UserID: user.ID, Limit: len(titles), Sort: sort, Descending: descending}
+				all, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatalf("%s: %v", sort, err)
+				}
+
+				params.Limit = 2
+				paged := []Video{}
+				for {
+					page, err := c.ListVideos(params)
+					if err != nil {
+						t.Fatalf("%s: %v", sort, err)
+					}
+					paged = append(paged, page.Videos...)
+					if page.Next == nil {
+						break
+					}
+					after, err := DecodeVideoCursor(page.Next.Encode())
+					if err != nil {
+						t.Fatalf("%s: decoding the next cursor: %v", sort, err)
+					}
+					params.After = &after
+				}
+
+				if len(paged) != len(all.Videos) {
+					t.Errorf("%s descending %v: paging found %d videos, want %d", sort, descending, len(paged), len(all.Videos))
+					continue
+				}
+				for i := range paged {
+					if paged[i].ID != all.Videos[i].ID {
+						t.Errorf("%s descending %v: video %d is %s paged, %s in one page", sort, descending, i, paged[i].ID, all.Videos[i].ID)
+						break
+					}
+				}
+			}
+		}
+
+		// A cursor only continues the listing it came from.
+		first, err := c.ListVideos(ListVideosParams{UserID: user.ID, Limit: 1, Sort: VideoSortTitle})
+		if err != nil || first.Next == nil {
+			t.Fatalf("first page = %v, %v", first.Next, err)
+		}
+		_, err = c.ListVideos(ListVideosParams{UserID: user.ID, Limit: 1, Sort: VideoSortTitle, Descending: true, After: first.Next})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("continuing with a cursor from another order = %v, want ErrInvalidCursor", err)
+		}
+	})
+}
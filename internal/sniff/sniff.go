@@ -0,0 +1,130 @@
+// Package sniff identifies uploads from their leading bytes rather than the
+// Content-Type a client claims, and flags files that would also parse as
+// markup or documents (polyglots) if a browser were to sniff them.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// HeaderSize is how much of a file Detect needs to see.
+const HeaderSize = 512
+
+// SniffWindowSize is the most of a file a browser reads when sniffing its
+// type (the "resource header" of the WHATWG MIME Sniffing standard). PDF
+// readers look for their marker within the first kilobyte, so this covers
+// them too. Markup past this point can't change how a file is handled.
+const SniffWindowSize = 1445
+
+// mp4Brands are ISO BMFF major brands of plain MP4 files. QuickTime ("qt  ")
+// shares the box structure but is reported separately.
+var mp4Brands = map[string]bool{
+	"isom": true,
+	"iso2": true,
+	"iso4": true,
+	"iso5": true,
+	"iso6": true,
+	"mp41": true,
+	"mp42": true,
+	"avc1": true,
+	"M4V ": true,
+	"M4VH": true,
+	"M4VP": true,
+	"dash": true,
+	"MSNV": true,
+	"3gp4": true,
+	"3gp5": true,
+	"3gp6": true,
+}
+
+// Detect returns the media type of data, or "" when it isn't one of the
+// formats we accept.
+func Detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	}
+	// Matroska and WebM share the EBML header and differ only in DocType,
+	// which sits within the first few dozen bytes.
+	if bytes.HasPrefix(data, []byte("\x1a\x45\xdf\xa3")) {
+		head := data[:min(len(data), 64)]
+		switch {
+		case bytes.Contains(head, []byte("webm")):
+			return "video/webm"
+		case bytes.Contains(head, []byte("matroska")):
+			return "video/x-matroska"
+		}
+		return ""
+	}
+	if brand, ok := ftypBrand(data); ok {
+		if brand == "qt  " {
+			return "video/quicktime"
+		}
+		if mp4Brands[brand] {
+			return "video/mp4"
+		}
+	}
+	return ""
+}
+
+// ftypBrand reads the major brand of an ISO BMFF file, which must open with
+// an ftyp box.
+func ftypBrand(data []byte) (string, bool) {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return "", false
+	}
+	size := binary.BigEndian.Uint32(data[0:4])
+	if size < 16 || size > 4096 {
+		return "", false
+	}
+	return string(data[8:12]), true
+}
+
+// markers are byte sequences that make a browser or PDF reader treat a file
+// as something other than media. Matched case-insensitively.
+var markers = [][]byte{
+	[]byte("<!doctype"),
+	[]byte("<html"),
+	[]byte("<head"),
+	[]byte("<body"),
+	[]byte("<script"),
+	[]byte("<iframe"),
+	[]byte("<svg"),
+	[]byte("<?xml"),
+	[]byte("<?php"),
+	[]byte("%pdf-"),
+	[]byte("javascript:"),
+}
+
+// ContainsMarkup reports whether data carries HTML, script, SVG, XML or PDF
+// markers anywhere in it.
+func ContainsMarkup(data []byte) bool {
+	lower := bytes.ToLower(data)
+	for _, marker := range markers {
+		if bytes.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadHeader reads up to HeaderSize bytes from the start of r.
+func ReadHeader(r io.ReaderAt) ([]byte, error) {
+	return ReadPrefix(r, HeaderSize)
+}
+
+// ReadPrefix reads up to n bytes from the start of r.
+func ReadPrefix(r io.ReaderAt, n int) ([]byte, error) {
+	prefix := make([]byte, n)
+	read, err := r.ReadAt(prefix, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return prefix[:read], nil
+}
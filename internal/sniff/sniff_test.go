@@ -0,0 +1,96 @@
+package sniff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ftyp builds the opening ftyp box of an ISO BMFF file with the given size
+// field and major brand.
+func ftyp(size byte, brand string) []byte {
+	return append([]byte{0, 0, 0, size, 'f', 't', 'y', 'p'}, []byte(brand+"\x00\x00\x02\x00isom")...)
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"JPEG", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		{"WebP", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"RIFF but WAVE", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ""},
+		{"WebM", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "video/webm"},
+		{"Matroska", []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska"), "video/x-matroska"},
+		{"EBML with another DocType", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84abcd"), ""},
+		{"MP4", ftyp(0x20, "isom"), "video/mp4"},
+		{"MP4 with mp42 brand", ftyp(0x18, "mp42"), "video/mp4"},
+		{"QuickTime", ftyp(0x14, "qt  "), "video/quicktime"},
+		{"HEIC", ftyp(0x18, "heic"), ""},
+		{"ftyp box too small", ftyp(0x08, "isom"), ""},
+		{"truncated ftyp", []byte("\x00\x00\x00\x20ftypis"), ""},
+		{"GIF", []byte("GIF89a"), ""},
+		{"HTML", []byte("<!DOCTYPE html><html>"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.data); got != tt.want {
+			t.Errorf("%s: Detect(%q) = %q, want %q", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestContainsMarkup(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"\xff\xd8\xff\xe0 plain image bytes", false},
+		{"\xff\xd8\xff\xe0<script>alert(1)</script>", true},
+		{"\x89PNG <HTML><BODY>", true},
+		{"GIF89a<SvG onload=x>", true},
+		{"%PDF-1.7", true},
+		{"JavaScript:alert(1)", true},
+		{"<?xml version=\"1.0\"?>", true},
+		{"a < b and c > d", false},
+		{"<scrip t>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ContainsMarkup([]byte(tt.data)); got != tt.want {
+			t.Errorf("ContainsMarkup(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReadPrefix(t *testing.T) {
+	long := strings.Repeat("x", HeaderSize+10)
+	tests := []struct {
+		data string
+		n    int
+		want string
+	}{
+		{"", HeaderSize, ""},
+		{"short", HeaderSize, "short"},
+		{long, HeaderSize, long[:HeaderSize]},
+		{long, SniffWindowSize, long},
+		{"abcdef", 3, "abc"},
+	}
+	for _, tt := range tests {
+		got, err := ReadPrefix(bytes.NewReader([]byte(tt.data)), tt.n)
+		if err != nil {
+			t.Errorf("ReadPrefix(%d bytes, %d): %v", len(tt.data), tt.n, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ReadPrefix(%d bytes, %d) = %d bytes, want %d", len(tt.data), tt.n, len(got), len(tt.want))
+		}
+	}
+
+	header, err := ReadHeader(bytes.NewReader([]byte(long)))
+	if err != nil || len(header) != HeaderSize {
+		t.Errorf("ReadHeader = %d bytes, %v; want %d", len(header), err, HeaderSize)
+	}
+}
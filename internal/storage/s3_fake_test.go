@@ -0,0 +1,537 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeS3 is just enough of the S3 REST API, held in memory, to exercise
+// S3Store against: objects, ListObjectsV2, multipart uploads, presigned GETs
+// and browser POST uploads. Ordinary requests aren't authenticated, but
+// presigned GET and POST signatures are checked against the credentials it
+// was created with, since building them is our code's job rather than the
+// SDK's.
+type fakeS3 struct {
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	// listPageSize keeps ListObjectsV2 pages small so pagination runs.
+	listPageSize int
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload
+	nextID  int
+	// failParts is how many UploadPart requests fail before they succeed.
+	failParts int
+	// hosts records the Host of every request.
+	hosts []string
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+type fakeUpload struct {
+	key         string
+	contentType string
+	initiated   time.Time
+	parts       map[int][]byte
+}
+
+func newFakeS3(bucket, region, accessKeyID, secretAccessKey string) *fakeS3 {
+	return &fakeS3{
+		bucket:          bucket,
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		listPageSize:    2,
+		objects:         map[string]fakeObject{},
+		uploads:         map[string]*fakeUpload{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts = append(f.hosts, r.Host)
+
+	// Virtual-hosted requests name the bucket in the host, path-style ones
+	// in the first path segment.
+	var bucket, key string
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if host, _, _ := strings.Cut(r.Host, ":"); strings.HasPrefix(host, f.bucket+".") {
+		bucket, key = f.bucket, path
+	} else {
+		bucket, key, _ = strings.Cut(path, "/")
+	}
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodPost:
+		f.postObject(w, r)
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		f.listUploads(w)
+	case key == "" && r.Method == http.MethodGet:
+		f.listObjects(w, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.createUpload(w, r, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.uploadPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeUpload(w, r, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.putObject(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		f.getObject(w, r, key)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	data, ok := readCheckedBody(w, r)
+	if !ok {
+		return
+	}
+	f.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now().UTC()}
+	w.Header().Set("ETag", etag(data))
+}
+
+func (f *fakeS3) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	if r.URL.Query().Has("X-Amz-Signature") {
+		if err := f.verifyPresignedURL(r); err != nil {
+			writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+			return
+		}
+	}
+	object, ok := f.objects[key]
+	if !ok {
+		// HEAD responses carry no body, so clients only see the status.
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+	w.Header().Set("Content-Type", object.contentType)
+	w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+	w.Header().Set("ETag", etag(object.data))
+	if r.Method == http.MethodGet {
+		w.Write(object.data)
+	}
+}
+
+type fakeListResult struct {
+	XMLName               xml.Name          `xml:"ListBucketResult"`
+	Name                  string            `xml:"Name"`
+	Prefix                string            `xml:"Prefix"`
+	KeyCount              int               `xml:"KeyCount"`
+	IsTruncated           bool              `xml:"IsTruncated"`
+	NextContinuationToken string            `xml:"NextContinuationToken,omitempty"`
+	Contents              []fakeListContent `xml:"Contents"`
+}
+
+type fakeListContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int    `xml:"Size"`
+	ETag         string `xml:"ETag"`
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := fakeListResult{Name: f.bucket, Prefix: prefix}
+	if len(keys) > f.listPageSize {
+		keys = keys[:f.listPageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		object := f.objects[key]
+		result.Contents = append(result.Contents, fakeListContent{
+			Key:          key,
+			LastModified: object.modified.Format("2006-01-02T15:04:05.000Z"),
+			Size:         len(object.data),
+			ETag:         etag(object.data),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func (f *fakeS3) createUpload(w http.ResponseWriter, r *http.Request, key string) {
+	f.nextID++
+	uploadID := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[uploadID] = &fakeUpload{
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		initiated:   time.Now().UTC(),
+		parts:       map[int][]byte{},
+	}
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: f.bucket, Key: key, UploadID: uploadID})
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	upload, ok := f.uploads[query.Get("uploadId")]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	data, ok := readCheckedBody(w, r)
+	if !ok {
+		return
+	}
+	if f.failParts > 0 {
+		f.failParts--
+		writeS3Error(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	upload.parts[partNumber] = data
+	w.Header().Set("ETag", etag(data))
+	if r.Header.Get("X-Amz-Checksum-Sha256") != "" {
+		w.Header().Set("X-Amz-Checksum-Sha256", checksumOf(data))
+	}
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, key, uploadID string) {
+	upload, ok := f.uploads[uploadID]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var data []byte
+	for i, part := range request.Parts {
+		stored, ok := upload.parts[part.PartNumber]
+		if part.PartNumber != i+1 || !ok || part.ETag != etag(stored) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		if i < len(request.Parts)-1 && len(stored) < minPartSize {
+			writeS3Error(w, http.StatusBadRequest, "EntityTooSmall")
+			return
+		}
+		data = append(data, stored...)
+	}
+	f.objects[key] = fakeObject{data: data, contentType: upload.contentType, modified: time.Now().UTC()}
+	delete(f.uploads, uploadID)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: f.bucket, Key: key, ETag: etag(data)})
+}
+
+func (f *fakeS3) listUploads(w http.ResponseWriter) {
+	type fakeUploadEntry struct {
+		Key       string `xml:"Key"`
+		UploadID  string `xml:"UploadId"`
+		Initiated string `xml:"Initiated"`
+	}
+	result := struct {
+		XMLName     xml.Name          `xml:"ListMultipartUploadsResult"`
+		Bucket      string            `xml:"Bucket"`
+		IsTruncated bool              `xml:"IsTruncated"`
+		Uploads     []fakeUploadEntry `xml:"Upload"`
+	}{Bucket: f.bucket}
+	for id, upload := range f.uploads {
+		result.Uploads = append(result.Uploads, fakeUploadEntry{
+			Key:       upload.key,
+			UploadID:  id,
+			Initiated: upload.initiated.Format("2006-01-02T15:04:05.000Z"),
+		})
+	}
+	writeXML(w, result)
+}
+
+// postObject handles a browser form upload, checking the signed policy the
+// way S3 does before storing the file.
+func (f *fakeS3) postObject(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest")
+		return
+	}
+	field := r.PostForm.Get
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	credential := strings.Split(field("x-amz-credential"), "/")
+	if len(credential) != 5 || credential[0] != f.accessKeyID || credential[2] != f.region {
+		writeS3Error(w, http.StatusForbidden, "InvalidAccessKeyId")
+		return
+	}
+	signature := hex.EncodeToString(hmacSHA256(f.signingKey(credential[1]), field("policy")))
+	if !hmac.Equal([]byte(signature), []byte(field("x-amz-signature"))) {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	document, err := base64.StdEncoding.DecodeString(field("policy"))
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidPolicyDocument")
+		return
+	}
+	var policy struct {
+		Expiration string `json:"expiration"`
+		Conditions []any  `json:"conditions"`
+	}
+	if err := json.Unmarshal(document, &policy); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidPolicyDocument")
+		return
+	}
+	expiration, err := time.Parse("2006-01-02T15:04:05.000Z", policy.Expiration)
+	if err != nil || time.Now().After(expiration) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	for _, condition := range policy.Conditions {
+		if !f.postConditionHolds(condition, field, len(data)) {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+	}
+
+	f.objects[field("key")] = fakeObject{data: data, contentType: field("Content-Type"), modified: time.Now().UTC()}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeS3) postConditionHolds(condition any, field func(string) string, size int) bool {
+	switch c := condition.(type) {
+	case map[string]any:
+		for name, value := range c {
+			if name == "bucket" {
+				return value == f.bucket
+			}
+			return field(name) == value
+		}
+	case []any:
+		if len(c) != 3 {
+			return false
+		}
+		switch c[0] {
+		case "starts-with":
+			name, _ := c[1].(string)
+			prefix, _ := c[2].(string)
+			return strings.HasPrefix(field(strings.TrimPrefix(name, "$")), prefix)
+		case "content-length-range":
+			low, _ := c[1].(float64)
+			high, _ := c[2].(float64)
+			return float64(size) >= low && float64(size) <= high
+		}
+	}
+	return false
+}
+
+// verifyPresignedURL checks a Signature Version 4 query string signature.
+func (f *fakeS3) verifyPresignedURL(r *http.Request) error {
+	query := r.URL.Query()
+	credential := strings.Split(query.Get("X-Amz-Credential"), "/")
+	if len(credential) != 5 || credential[0] != f.accessKeyID || credential[2] != f.region {
+		return fmt.Errorf("credential %q", query.Get("X-Amz-Credential"))
+	}
+	date, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		return err
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || time.Now().After(date.Add(time.Duration(expires)*time.Second)) {
+		return fmt.Errorf("expired")
+	}
+
+	names := []string{}
+	for name := range query {
+		if name != "X-Amz-Signature" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pairs := []string{}
+	for _, name := range names {
+		pairs = append(pairs, awsEscape(name)+"="+awsEscape(query.Get(name)))
+	}
+	headers := ""
+	for _, name := range strings.Split(query.Get("X-Amz-SignedHeaders"), ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers += name + ":" + strings.TrimSpace(value) + "\n"
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		headers,
+		query.Get("X-Amz-SignedHeaders"),
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{
+		postAlgorithm,
+		query.Get("X-Amz-Date"),
+		strings.Join(credential[1:], "/"),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(f.signingKey(credential[1]), stringToSign))
+	if !hmac.Equal([]byte(signature), []byte(query.Get("X-Amz-Signature"))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func (f *fakeS3) signingKey(date string) []byte {
+	key := []byte("AWS4" + f.secretAccessKey)
+	for _, part := range []string{date, f.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as
+// SigV4 canonical query strings require.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// readCheckedBody reads a request body, undoing aws-chunked encoding, and
+// rejects it when it doesn't match its x-amz-checksum-sha256.
+func readCheckedBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	expected := r.Header.Get("X-Amz-Checksum-Sha256")
+	var data []byte
+	var err error
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		var trailers map[string]string
+		data, trailers, err = decodeAWSChunked(r.Body)
+		if value, ok := trailers["x-amz-checksum-sha256"]; ok {
+			expected = value
+		}
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return nil, false
+	}
+	if expected != "" && expected != checksumOf(data) {
+		writeS3Error(w, http.StatusBadRequest, "BadDigest")
+		return nil, false
+	}
+	return data, true
+}
+
+func decodeAWSChunked(body io.Reader) ([]byte, map[string]string, error) {
+	reader := bufio.NewReader(body)
+	var data bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		sizeField, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeField, 16, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, nil, err
+		}
+		if _, err := reader.Discard(2); err != nil {
+			return nil, nil, err
+		}
+	}
+	trailers := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" || err != nil {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		trailers[strings.ToLower(name)] = value
+	}
+	return data.Bytes(), trailers, nil
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, code)
+}
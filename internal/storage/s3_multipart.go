@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	minPartSize  = 5 << 20
+	maxPartCount = 10000
+)
+
+type S3Options struct {
+	// PartSize is both the multipart threshold and the size of every part
+	// except the last. S3 requires at least 5 MiB.
+	PartSize    int64
+	Concurrency int
+	PartRetries int
+}
+
+func DefaultS3Options() S3Options {
+	return S3Options{
+		PartSize:    64 << 20,
+		Concurrency: 4,
+		PartRetries: 3,
+	}
+}
+
+func (o S3Options) Validate() error {
+	if o.PartSize < minPartSize {
+		return fmt.Errorf("part size must be at least %d bytes", minPartSize)
+	}
+	if o.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	if o.PartRetries < 0 {
+		return fmt.Errorf("part retries can't be negative")
+	}
+	return nil
+}
+
+// putMultipart uploads body in parallel parts. If any part fails for good the
+// multipart upload is aborted so S3 doesn't keep (and bill for) the parts.
+//
+// S3 only keeps a checksum of the part checksums for multipart objects, so
+// with opts.ChecksumSHA256 set the body is checked against it up front and
+// every part is then sent with its own SHA-256 for S3 to verify.
+func (s *S3Store) putMultipart(ctx context.Context, key string, body io.ReaderAt, size int64, opts PutOptions) error {
+	withChecksums := opts.ChecksumSHA256 != ""
+	if withChecksums {
+		checksum := newChecksumVerifier(opts.ChecksumSHA256)
+		if _, err := io.Copy(checksum, io.NewSectionReader(body, 0, size)); err != nil {
+			return err
+		}
+		if err := checksum.verify(); err != nil {
+			return err
+		}
+	}
+
+	partSize := s.options.PartSize
+	if (size+partSize-1)/partSize > maxPartCount {
+		partSize = (size + maxPartCount - 1) / maxPartCount
+	}
+	partCount := int((size + partSize - 1) / partSize)
+
+	createParams := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		createParams.ContentType = aws.String(opts.ContentType)
+	}
+	if withChecksums {
+		createParams.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	created, err := s.client.CreateMultipartUpload(ctx, createParams)
+	if err != nil {
+		return err
+	}
+	uploadID := created.UploadId
+
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	completed := make([]types.CompletedPart, partCount)
+	partNumbers := make(chan int32)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for range min(s.options.Concurrency, partCount) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partNumbers {
+				offset := int64(partNumber-1) * partSize
+				length := min(partSize, size-offset)
+				part, err := s.uploadPart(uploadCtx, key, uploadID, partNumber, io.NewSectionReader(body, offset, length), length, withChecksums)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("part %d: %w", partNumber, err)
+						cancel()
+					})
+					return
+				}
+				completed[partNumber-1] = types.CompletedPart{
+					ETag:           part.ETag,
+					ChecksumSHA256: part.ChecksumSHA256,
+					PartNumber:     aws.Int32(partNumber),
+				}
+			}
+		}()
+	}
+
+sendParts:
+	for partNumber := int32(1); partNumber <= int32(partCount); partNumber++ {
+		select {
+		case partNumbers <- partNumber:
+		case <-uploadCtx.Done():
+			break sendParts
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr == nil && uploadCtx.Err() != nil {
+		firstErr = uploadCtx.Err()
+	}
+	if firstErr == nil {
+		_, firstErr = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(key),
+			UploadId:        uploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+		})
+	}
+	if firstErr != nil {
+		abortCtx, abortCancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer abortCancel()
+		_, abortErr := s.client.AbortMultipartUpload(abortCtx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: uploadID,
+		})
+		if abortErr != nil {
+			return fmt.Errorf("multipart upload failed: %w (abort also failed: %v)", firstErr, abortErr)
+		}
+		return fmt.Errorf("multipart upload failed: %w", translateS3Error(firstErr))
+	}
+	return nil
+}
+
+func (s *S3Store) uploadPart(ctx context.Context, key string, uploadID *string, partNumber int32, part *io.SectionReader, length int64, withChecksum bool) (*s3.UploadPartOutput, error) {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		params := &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          part,
+			ContentLength: aws.Int64(length),
+		}
+		if withChecksum {
+			// The SDK hashes the part and S3 rejects it if the bytes differ.
+			params.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+		}
+		out, err := s.client.UploadPart(ctx, params)
+		if err == nil {
+			return out, nil
+		}
+		if attempt >= s.options.PartRetries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// AbortIncompleteUploads aborts multipart uploads started before cutoff,
+// cleaning up after processes that died mid-upload.
+func (s *S3Store) AbortIncompleteUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	})
+
+	aborted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return aborted, err
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || upload.Initiated.After(cutoff) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PostPolicy describes what a browser may upload with a presigned POST. The
+// object must be stored under KeyPrefix; Key is the exact key the form
+// submits and has to start with it.
+type PostPolicy struct {
+	Key         string
+	KeyPrefix   string
+	ContentType string
+	MinSize     int64
+	MaxSize     int64
+	Expires     time.Duration
+}
+
+// PresignedPost is everything a browser needs to build the multipart form:
+// Fields go first, in any order, followed by the file itself.
+type PresignedPost struct {
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+const postAlgorithm = "AWS4-HMAC-SHA256"
+
+// PresignPost signs a POST policy with Signature Version 4. The SDK has no
+// helper for this, so the policy document and signing key are built here,
+// following "Browser-Based Uploads Using POST" in the S3 API reference.
+func (s *S3Store) PresignPost(ctx context.Context, policy PostPolicy) (PresignedPost, error) {
+	if !strings.HasPrefix(policy.Key, policy.KeyPrefix) {
+		return PresignedPost{}, errors.New("key must start with the key prefix")
+	}
+
+	options := s.client.Options()
+	creds, err := options.Credentials.Retrieve(ctx)
+	if err != nil {
+		return PresignedPost{}, fmt.Errorf("couldn't load credentials: %w", err)
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(policy.Expires)
+	date := now.Format("20060102")
+	credential := strings.Join([]string{creds.AccessKeyID, date, options.Region, "s3", "aws4_request"}, "/")
+
+	fields := map[string]string{
+		"key":              policy.Key,
+		"x-amz-algorithm":  postAlgorithm,
+		"x-amz-credential": credential,
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if policy.ContentType != "" {
+		fields["Content-Type"] = policy.ContentType
+	}
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+
+	conditions := []any{
+		map[string]string{"bucket": s.bucket},
+		[]any{"starts-with", "$key", policy.KeyPrefix},
+		[]any{"content-length-range", policy.MinSize, policy.MaxSize},
+	}
+	for name, value := range fields {
+		if name == "key" {
+			continue
+		}
+		conditions = append(conditions, map[string]string{name: value})
+	}
+
+	document, err := json.Marshal(map[string]any{
+		"expiration": expiresAt.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return PresignedPost{}, err
+	}
+	encodedPolicy := base64.StdEncoding.EncodeToString(document)
+
+	signingKey := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, options.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	fields["policy"] = encodedPolicy
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(signingKey, encodedPolicy))
+
+	return PresignedPost{
+		URL:       s.bucketURL(),
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// bucketURL is where a browser form posts to: the configured endpoint when
+// there is one, otherwise the regional virtual-hosted style address.
+func (s *S3Store) bucketURL() string {
+	options := s.client.Options()
+	if options.BaseEndpoint != nil {
+		endpoint := strings.TrimSuffix(*options.BaseEndpoint, "/")
+		if options.UsePathStyle {
+			return endpoint + "/" + s.bucket
+		}
+		scheme, host, _ := strings.Cut(endpoint, "://")
+		return scheme + "://" + s.bucket + "." + host
+	}
+	if options.UsePathStyle {
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s", options.Region, s.bucket)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, options.Region)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	testBucket = "tubely-test"
+	testRegion = "us-east-2"
+)
+
+// testS3EndpointEnv names an S3-compatible server, e.g. MinIO at
+// http://localhost:9000 or LocalStack at http://localhost:4566, to run the
+// store tests against as well as the fake. Credentials come from
+// TEST_S3_ACCESS_KEY_ID and TEST_S3_SECRET_ACCESS_KEY, and the region from
+// TEST_S3_REGION. Each test gets a bucket of its own there, emptied and
+// removed afterwards. Without it, or when the server can't be reached, those
+// runs are skipped.
+const testS3EndpointEnv = "TEST_S3_ENDPOINT"
+
+// newLiveS3Store returns a store on a fresh bucket on the server named by
+// TEST_S3_ENDPOINT, addressed path-style since local servers rarely resolve
+// bucket host names.
+func newLiveS3Store(t *testing.T, options S3Options) *S3Store {
+	t.Helper()
+	endpoint := os.Getenv(testS3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s not set", testS3EndpointEnv)
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		t.Fatalf("parsing %s: %q isn't a URL", testS3EndpointEnv, endpoint)
+	}
+	conn, err := net.DialTimeout("tcp", endpointURL.Host, 2*time.Second)
+	if err != nil {
+		t.Skipf("S3 server isn't available: %v", err)
+	}
+	conn.Close()
+
+	region := os.Getenv("TEST_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	client := s3.New(s3.Options{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider(os.Getenv("TEST_S3_ACCESS_KEY_ID"), os.Getenv("TEST_S3_SECRET_ACCESS_KEY"), ""),
+	}, WithEndpoint(endpoint, true))
+
+	ctx := context.Background()
+	bucket := "tubely-test-" + strings.ToLower(strconv.FormatInt(time.Now().UnixNano(), 36))
+	if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		t.Fatalf("creating bucket %s: %v", bucket, err)
+	}
+	store := NewS3Store(client, bucket, options)
+	t.Cleanup(func() {
+		objects, err := store.List(ctx, "")
+		if err != nil {
+			t.Errorf("listing bucket %s: %v", bucket, err)
+			return
+		}
+		for _, object := range objects {
+			if err := store.Delete(ctx, object.Key); err != nil {
+				t.Errorf("emptying bucket %s: %v", bucket, err)
+			}
+		}
+		if _, err := store.AbortIncompleteUploads(ctx, time.Now()); err != nil {
+			t.Errorf("aborting uploads in bucket %s: %v", bucket, err)
+		}
+		if _, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Errorf("removing bucket %s: %v", bucket, err)
+		}
+	})
+	return store
+}
+
+// newFakeS3Store starts a fake S3 server and returns a store talking to it
+// through a custom endpoint. The endpoint uses a made-up host name, dialled
+// straight to the server, so virtual-hosted addressing (bucket.host) works
+// without DNS.
+func newFakeS3Store(t *testing.T, pathStyle bool, options S3Options) (*S3Store, *fakeS3, *http.Client) {
+	t.Helper()
+	fake := newFakeS3(testBucket, testRegion, "AKIDTEST", "secret")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	dialer := &net.Dialer{}
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}}
+	t.Cleanup(httpClient.CloseIdleConnections)
+
+	client := s3.New(s3.Options{
+		Region:           testRegion,
+		Credentials:      credentials.NewStaticCredentialsProvider("AKIDTEST", "secret", "session-token"),
+		HTTPClient:       httpClient,
+		RetryMaxAttempts: 1,
+	}, WithEndpoint("http://s3.fake.test:"+port, pathStyle))
+	return NewS3Store(client, testBucket, options), fake, httpClient
+}
+
+func TestS3Store(t *testing.T) {
+	for _, pathStyle := range []bool{true, false} {
+		name := "virtual-hosted"
+		if pathStyle {
+			name = "path-style"
+		}
+		t.Run(name, func(t *testing.T) {
+			store, fake, _ := newFakeS3Store(t, pathStyle, DefaultS3Options())
+			testBlobStore(t, store)
+
+			// Make sure the requests were addressed the way we asked.
+			for _, host := range fake.hosts {
+				if strings.HasPrefix(host, testBucket+".") == pathStyle {
+					t.Fatalf("request to host %s with path style %v", host, pathStyle)
+				}
+			}
+		})
+	}
+}
+
+// TestS3StoreLive runs the shared suite against a real S3-compatible server,
+// which the fake only approximates.
+func TestS3StoreLive(t *testing.T) {
+	options := DefaultS3Options()
+	options.PartSize = minPartSize
+	store := newLiveS3Store(t, options)
+	testBlobStore(t, store)
+
+	// Large enough to go through a multipart upload.
+	data := bytes.Repeat([]byte("0123456789abcdef"), int(options.PartSize/16)+1)
+	ctx := context.Background()
+	err := store.Put(ctx, "large.bin", bytes.NewReader(data), PutOptions{Size: int64(len(data)), ChecksumSHA256: checksumOf(data)})
+	if err != nil {
+		t.Fatalf("multipart Put: %v", err)
+	}
+	body, _, err := store.Get(ctx, "large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("multipart upload read back %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestS3StoreMultipart(t *testing.T) {
+	options := S3Options{PartSize: minPartSize, Concurrency: 2, PartRetries: 1}
+	// Three parts, the last one short.
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+minPartSize/2)/16)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		checksum  string
+		failParts int
+		wantErr   error
+	}{
+		{"without checksum", "", 0, nil},
+		{"with checksum", checksumOf(data), 0, nil},
+		{"retried part", checksumOf(data), 1, nil},
+		{"wrong checksum", checksumOf([]byte("something else")), 0, ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, fake, _ := newFakeS3Store(t, true, options)
+			fake.failParts = tt.failParts
+
+			err := store.Put(ctx, "big.mp4", bytes.NewReader(data), PutOptions{
+				ContentType:    "video/mp4",
+				Size:           int64(len(data)),
+				ChecksumSHA256: tt.checksum,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Put = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, ok := fake.objects["big.mp4"]; ok {
+					t.Error("object stored despite the error")
+				}
+				return
+			}
+
+			object, ok := fake.objects["big.mp4"]
+			if !ok || !bytes.Equal(object.data, data) || object.contentType != "video/mp4" {
+				t.Fatalf("stored %d bytes as %q, want %d bytes as video/mp4", len(object.data), object.contentType, len(data))
+			}
+			if len(fake.uploads) != 0 {
+				t.Errorf("%d multipart uploads left open", len(fake.uploads))
+			}
+		})
+	}
+
+	t.Run("aborted on failure", func(t *testing.T) {
+		store, fake, _ := newFakeS3Store(t, true, options)
+		// More failures than one part's retries allow.
+		fake.failParts = 100
+
+		err := store.Put(ctx, "big.mp4", bytes.NewReader(data), PutOptions{Size: int64(len(data))})
+		if err == nil {
+			t.Fatal("Put succeeded with every part failing")
+		}
+		if _, ok := fake.objects["big.mp4"]; ok {
+			t.Error("object stored despite the failure")
+		}
+		if len(fake.uploads) != 0 {
+			t.Errorf("%d multipart uploads left open after a failure", len(fake.uploads))
+		}
+	})
+}
+
+func TestS3StoreAbortIncompleteUploads(t *testing.T) {
+	store, fake, _ := newFakeS3Store(t, true, DefaultS3Options())
+	ctx := context.Background()
+
+	for _, key := range []string{"old-1.mp4", "old-2.mp4", "new.mp4"} {
+		if _, err := store.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, upload := range fake.uploads {
+		if strings.HasPrefix(upload.key, "old-") {
+			upload.initiated = upload.initiated.Add(-48 * time.Hour)
+		}
+	}
+
+	aborted, err := store.AbortIncompleteUploads(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("AbortIncompleteUploads: %v", err)
+	}
+	if aborted != 2 {
+		t.Errorf("aborted %d uploads, want 2", aborted)
+	}
+	if len(fake.uploads) != 1 {
+		t.Fatalf("%d uploads left, want 1", len(fake.uploads))
+	}
+	for _, upload := range fake.uploads {
+		if upload.key != "new.mp4" {
+			t.Errorf("left %s open, want new.mp4", upload.key)
+		}
+	}
+}
+
+func TestS3StorePresignGet(t *testing.T) {
+	for _, pathStyle := range []bool{true, false} {
+		store, _, httpClient := newFakeS3Store(t, pathStyle, DefaultS3Options())
+		ctx := context.Background()
+		data := []byte("presigned")
+		if err := store.Put(ctx, "presign/me.txt", bytes.NewReader(data), PutOptions{Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+
+		rawURL, err := store.PresignGet(ctx, "presign/me.txt", time.Minute)
+		if err != nil {
+			t.Fatalf("PresignGet: %v", err)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Query().Get("X-Amz-Security-Token") != "session-token" || u.Query().Get("X-Amz-Expires") != "60" {
+			t.Errorf("presigned URL %s is missing the session token or expiry", rawURL)
+		}
+
+		resp, err := httpClient.Get(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(got, data) {
+			t.Errorf("path style %v: GET %s = %d %q, want 200 %q", pathStyle, rawURL, resp.StatusCode, got, data)
+		}
+
+		// Changing anything signed has to break the signature.
+		query := u.Query()
+		query.Set("X-Amz-Expires", "3600")
+		u.RawQuery = query.Encode()
+		resp, err = httpClient.Get(u.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("path style %v: tampered URL = %d, want 403", pathStyle, resp.StatusCode)
+		}
+	}
+}
+
+func TestS3StorePresignPost(t *testing.T) {
+	policy := PostPolicy{
+		Key:         "uploads/user-1/video.mp4",
+		KeyPrefix:   "uploads/user-1/",
+		ContentType: "video/mp4",
+		MinSize:     1,
+		MaxSize:     16,
+		Expires:     time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(fields map[string]string)
+		file    []byte
+		wantKey bool
+	}{
+		{"as signed", nil, []byte("small video"), true},
+		{"too large", nil, bytes.Repeat([]byte("x"), 17), false},
+		{"empty", nil, []byte{}, false},
+		{"other prefix", func(fields map[string]string) { fields["key"] = "uploads/user-2/video.mp4" }, []byte("small video"), false},
+		{"other content type", func(fields map[string]string) { fields["Content-Type"] = "text/html" }, []byte("small video"), false},
+		{"tampered policy", func(fields map[string]string) { fields["policy"] += "=" }, []byte("small video"), false},
+	}
+	for _, pathStyle := range []bool{true, false} {
+		for _, tt := range tests {
+			store, fake, httpClient := newFakeS3Store(t, pathStyle, DefaultS3Options())
+			post, err := store.PresignPost(context.Background(), policy)
+			if err != nil {
+				t.Fatalf("PresignPost: %v", err)
+			}
+			if post.Fields["x-amz-security-token"] != "session-token" {
+				t.Errorf("fields %v are missing the session token", post.Fields)
+			}
+			if tt.edit != nil {
+				tt.edit(post.Fields)
+			}
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			for name, value := range post.Fields {
+				form.WriteField(name, value)
+			}
+			file, _ := form.CreateFormFile("file", "video.mp4")
+			file.Write(tt.file)
+			form.Close()
+
+			resp, err := httpClient.Post(post.URL, form.FormDataContentType(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			_, stored := fake.objects[post.Fields["key"]]
+			if stored != tt.wantKey || (resp.StatusCode == http.StatusNoContent) != tt.wantKey {
+				t.Errorf("path style %v, %s: POST = %d, stored %v; want stored %v", pathStyle, tt.name, resp.StatusCode, stored, tt.wantKey)
+			}
+		}
+	}
+
+	store, _, _ := newFakeS3Store(t, true, DefaultS3Options())
+	if _, err := store.PresignPost(context.Background(), PostPolicy{Key: "elsewhere/video.mp4", KeyPrefix: "uploads/"}); err == nil {
+		t.Error("PresignPost accepted a key outside its prefix")
+	}
+}
+
+func TestS3StoreBucketURL(t *testing.T) {
+	tests := []struct {
+		endpoint  string
+		pathStyle bool
+		want      string
+	}{
+		{"", false, "https://tubely-test.s3.us-east-2.amazonaws.com"},
+		{"", true, "https://s3.us-east-2.amazonaws.com/tubely-test"},
+		{"http://localhost:9000/", true, "http://localhost:9000/tubely-test"},
+		{"https://storage.example.com", false, "https://tubely-test.storage.example.com"},
+	}
+	for _, tt := range tests {
+		client := s3.New(s3.Options{Region: testRegion}, WithEndpoint(tt.endpoint, tt.pathStyle))
+		store := NewS3Store(client, testBucket, DefaultS3Options())
+		if got := store.bucketURL(); got != tt.want {
+			t.Errorf("bucketURL with endpoint %q, path style %v = %s, want %s", tt.endpoint, tt.pathStyle, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("object not found")
+	ErrChecksumMismatch = errors.New("object checksum mismatch")
+)
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type PutOptions struct {
+	ContentType string
+	// Size is the body length in bytes, or 0 if unknown.
+	Size int64
+	// ChecksumSHA256 is the base64-encoded SHA-256 of the body, or empty to
+	// skip the check. Stores refuse the write with ErrChecksumMismatch when
+	// the bytes they received don't match.
+	ChecksumSHA256 string
+}
+
+// checksumVerifier hashes what a store writes so it can be compared with
+// PutOptions.ChecksumSHA256 before the object becomes visible.
+type checksumVerifier struct {
+	expected string
+	hash     hash.Hash
+}
+
+func newChecksumVerifier(expected string) *checksumVerifier {
+	return &checksumVerifier{expected: expected, hash: sha256.New()}
+}
+
+func (v *checksumVerifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+func (v *checksumVerifier) verify() error {
+	if v.expected == "" {
+		return nil
+	}
+	if base64.StdEncoding.EncodeToString(v.hash.Sum(nil)) != v.expected {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
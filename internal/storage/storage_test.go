@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testBlobStore(t, NewMemoryStore())
+}
+
+func TestLocalStore(t *testing.T) {
+	testBlobStore(t, NewLocalStore(t.TempDir(), "http://localhost:8091/assets"))
+}
+
+func TestLocalStoreRejectsKeysOutsideRoot(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://localhost:8091/assets")
+	ctx := context.Background()
+	for _, key := range []string{"", "../escape.txt", "a/../../escape.txt", "/absolute.txt", "a//b.txt"} {
+		if err := store.Put(ctx, key, bytes.NewReader([]byte("x")), PutOptions{}); err == nil {
+			t.Errorf("Put(%q) succeeded, want an error", key)
+		}
+	}
+
+	objects, err := store.List(ctx, "../")
+	if err != nil {
+		t.Fatalf("List(../): %v", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("List(../) = %v, want nothing", objects)
+	}
+}
+
+func checksumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// testBlobStore checks the behaviour every BlobStore has to share, so the
+// backends stay interchangeable. It expects an empty store.
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	put := func(t *testing.T, key string, data []byte, opts PutOptions) {
+		t.Helper()
+		opts.Size = int64(len(data))
+		if err := store.Put(ctx, key, bytes.NewReader(data), opts); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	keys := func(t *testing.T, prefix string) []string {
+		t.Helper()
+		objects, err := store.List(ctx, prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		keys := []string{}
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		data := []byte("not really a video")
+		put(t, "landscape/abc.mp4", data, PutOptions{ContentType: "video/mp4"})
+
+		body, info, err := store.Get(ctx, "landscape/abc.mp4")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		defer body.Close()
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Get body = %q, want %q", got, data)
+		}
+		if info.Key != "landscape/abc.mp4" || info.Size != int64(len(data)) || info.ContentType != "video/mp4" {
+			t.Errorf("Get info = %+v", info)
+		}
+
+		head, err := store.Head(ctx, "landscape/abc.mp4")
+		if err != nil {
+			t.Fatalf("Head: %v", err)
+		}
+		if head.Size != int64(len(data)) || head.ContentType != "video/mp4" {
+			t.Errorf("Head = %+v", head)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		put(t, "overwrite.txt", []byte("first"), PutOptions{})
+		put(t, "overwrite.txt", []byte("second, longer"), PutOptions{})
+		head, err := store.Head(ctx, "overwrite.txt")
+		if err != nil {
+			t.Fatalf("Head: %v", err)
+		}
+		if head.Size != int64(len("second, longer")) {
+			t.Errorf("Head size = %d after overwrite", head.Size)
+		}
+	})
+
+	t.Run("missing objects", func(t *testing.T) {
+		if _, _, err := store.Get(ctx, "missing.txt"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get = %v, want ErrNotFound", err)
+		}
+		if _, err := store.Head(ctx, "missing.txt"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Head = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, "missing.txt"); err != nil {
+			t.Errorf("Delete = %v, want nil", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put(t, "delete/me.txt", []byte("bye"), PutOptions{})
+		if err := store.Delete(ctx, "delete/me.txt"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := store.Head(ctx, "delete/me.txt"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Head after Delete = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("list by prefix", func(t *testing.T) {
+		for _, key := range []string{"list/b/2.ts", "list/a.m3u8", "list/b/1.ts", "listing.txt", "other/list/x.ts"} {
+			put(t, key, []byte(key), PutOptions{})
+		}
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{"list/", []string{"list/a.m3u8", "list/b/1.ts", "list/b/2.ts"}},
+			{"list/b", []string{"list/b/1.ts", "list/b/2.ts"}},
+			{"list/b/1", []string{"list/b/1.ts"}},
+			{"list", []string{"list/a.m3u8", "list/b/1.ts", "list/b/2.ts", "listing.txt"}},
+			{"nothing/", []string{}},
+		}
+		for _, tt := range tests {
+			got := keys(t, tt.prefix)
+			if len(got) != len(tt.want) {
+				t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		data := []byte("checked")
+		put(t, "checksum/good.txt", data, PutOptions{ChecksumSHA256: checksumOf(data)})
+
+		err := store.Put(ctx, "checksum/bad.txt", bytes.NewReader(data), PutOptions{
+			Size:           int64(len(data)),
+			ChecksumSHA256: checksumOf([]byte("something else")),
+		})
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("Put with the wrong checksum = %v, want ErrChecksumMismatch", err)
+		}
+		if _, err := store.Head(ctx, "checksum/bad.txt"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("object stored despite checksum mismatch: Head = %v", err)
+		}
+	})
+
+	t.Run("presign", func(t *testing.T) {
+		put(t, "presign/me.txt", []byte("link"), PutOptions{})
+		url, err := store.PresignGet(ctx, "presign/me.txt", time.Minute)
+		if err != nil {
+			t.Fatalf("PresignGet: %v", err)
+		}
+		if url == "" {
+			t.Error("PresignGet returned an empty URL")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+// Package urlbuilder turns storage keys into the public links handed to
+// clients, and parses those links back into keys, from one place configured
+// at startup.
+package urlbuilder
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Config struct {
+	// PublicBaseURL is where this server is reachable from clients, e.g.
+	// https://tubely.example.com when running behind a reverse proxy.
+	PublicBaseURL string
+	// CDNDomain is the host (or base URL) of the CDN in front of the bucket.
+	CDNDomain string
+	// S3Endpoint overrides the AWS endpoint for S3-compatible stores.
+	S3Endpoint string
+	// S3PathStyle puts the bucket in the path instead of the host name.
+	S3PathStyle bool
+	Bucket      string
+	Region      string
+}
+
+type Builder struct {
+	publicBase *url.URL
+	cdnBase    *url.URL
+	s3Base     *url.URL
+	pathStyle  bool
+	bucket     string
+}
+
+func New(cfg Config) (*Builder, error) {
+	if cfg.PublicBaseURL == "" {
+		return nil, errors.New("public base URL must be set")
+	}
+	publicBase, err := parseBase(cfg.PublicBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public base URL: %w", err)
+	}
+
+	b := &Builder{
+		publicBase: publicBase,
+		pathStyle:  cfg.S3PathStyle,
+		bucket:     cfg.Bucket,
+	}
+
+	if cfg.CDNDomain != "" {
+		b.cdnBase, err = parseBase(cfg.CDNDomain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CDN domain: %w", err)
+		}
+	}
+
+	s3Endpoint := cfg.S3Endpoint
+	if s3Endpoint == "" {
+		s3Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	b.s3Base, err = parseBase(s3Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	return b, nil
+}
+
+// parseBase accepts a bare host name as shorthand for https://host.
+func parseBase(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", raw)
+	}
+	return u, nil
+}
+
+// Public joins an absolute path onto the public base URL.
+func (b *Builder) Public(path string) string {
+	return b.publicBase.String() + path
+}
+
+// AssetBase is the URL the /assets/ file server is reachable at.
+func (b *Builder) AssetBase() string {
+	return b.Public("/assets")
+}
+
+// AssetKey returns the key of an asset URL, ignoring the host so links
+// written under an older base URL still resolve.
+func (b *Builder) AssetKey(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	key, ok := strings.CutPrefix(u.Path, b.publicBase.Path+"/assets/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// HasCDN reports whether a CDN domain is configured.
+func (b *Builder) HasCDN() bool {
+	return b.cdnBase != nil
+}
+
+// CDN is the CDN URL of an object. Wildcards are left unescaped so the
+// result can double as a CloudFront policy resource. Only valid when HasCDN;
+// it's used for CloudFront signing, and the server won't start with a key
+// pair but no CDN.
+func (b *Builder) CDN(key string) string {
+	return b.cdnBase.String() + "/" + escapeKey(key)
+}
+
+// S3 is the direct bucket URL of an object, virtual-hosted unless path-style
+// addressing is configured.
+func (b *Builder) S3(key string) string {
+	if b.pathStyle {
+		return b.s3Base.String() + "/" + b.bucket + "/" + escapeKey(key)
+	}
+	base := *b.s3Base
+	base.Host = b.bucket + "." + base.Host
+	return base.String() + "/" + escapeKey(key)
+}
+
+// ObjectKey returns the key of a bucket or CDN URL. Path-style URLs carry
+// the bucket as their first path segment, which is not part of the key.
+func (b *Builder) ObjectKey(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	isCDN := b.cdnBase != nil && u.Host == b.cdnBase.Host
+	if !isCDN && !strings.HasPrefix(u.Host, b.bucket+".") {
+		key = strings.TrimPrefix(key, b.bucket+"/")
+	}
+	return key, key != ""
+}
+
+// escapeKey escapes each path segment of a key while keeping the slashes
+// and '*' wildcards.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "%2A", "*")
+	}
+	return strings.Join(segments, "/")
+}
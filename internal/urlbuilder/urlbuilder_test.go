@@ -0,0 +1,141 @@
+package urlbuilder
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantErr    bool
+		wantPublic string
+		wantCDN    bool
+	}{
+		{"bare CDN host", Config{PublicBaseURL: "http://localhost:8091", CDNDomain: "d111.cloudfront.net"}, false, "http://localhost:8091", true},
+		{"trailing slashes", Config{PublicBaseURL: "https://tubely.example.com/", CDNDomain: "https://cdn.example.com/"}, false, "https://tubely.example.com", true},
+		{"no CDN", Config{PublicBaseURL: "http://localhost:8091"}, false, "http://localhost:8091", false},
+		{"no public base", Config{}, true, "", false},
+		{"public base without host", Config{PublicBaseURL: "https://"}, true, "", false},
+		{"CDN without host", Config{PublicBaseURL: "http://localhost:8091", CDNDomain: "https://"}, true, "", false},
+	}
+	for _, tt := range tests {
+		b, err := New(tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: New error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got := b.Public(""); got != tt.wantPublic {
+			t.Errorf("%s: public base = %q, want %q", tt.name, got, tt.wantPublic)
+		}
+		if b.HasCDN() != tt.wantCDN {
+			t.Errorf("%s: HasCDN = %v, want %v", tt.name, b.HasCDN(), tt.wantCDN)
+		}
+	}
+}
+
+func TestCDN(t *testing.T) {
+	b, err := New(Config{PublicBaseURL: "http://localhost:8091", CDNDomain: "d111.cloudfront.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"landscape/abc.mp4", "https://d111.cloudfront.net/landscape/abc.mp4"},
+		{"*/out/*", "https://d111.cloudfront.net/*/out/*"},
+		{"a b/c?d#e.jpg", "https://d111.cloudfront.net/a%20b/c%3Fd%23e.jpg"},
+	}
+	for _, tt := range tests {
+		if got := b.CDN(tt.key); got != tt.want {
+			t.Errorf("CDN(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestS3(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		key  string
+		want string
+	}{
+		{"AWS", Config{Bucket: "tubely", Region: "us-east-2"}, "landscape/abc.mp4", "https://tubely.s3.us-east-2.amazonaws.com/landscape/abc.mp4"},
+		{"AWS, path-style", Config{Bucket: "tubely", Region: "us-east-2", S3PathStyle: true}, "landscape/abc.mp4", "https://s3.us-east-2.amazonaws.com/tubely/landscape/abc.mp4"},
+		{"MinIO", Config{Bucket: "tubely", S3Endpoint: "http://localhost:9000", S3PathStyle: true}, "hls/out/master.m3u8", "http://localhost:9000/tubely/hls/out/master.m3u8"},
+		{"endpoint, virtual-hosted", Config{Bucket: "tubely", S3Endpoint: "https://storage.example.com/", Region: "auto"}, "a.jpg", "https://tubely.storage.example.com/a.jpg"},
+		{"bare endpoint host", Config{Bucket: "tubely", S3Endpoint: "storage.example.com", S3PathStyle: true}, "a.jpg", "https://storage.example.com/tubely/a.jpg"},
+		{"escaped key", Config{Bucket: "tubely", Region: "us-east-2"}, "a b/c?d#e.jpg", "https://tubely.s3.us-east-2.amazonaws.com/a%20b/c%3Fd%23e.jpg"},
+	}
+	for _, tt := range tests {
+		tt.cfg.PublicBaseURL = "http://localhost:8091"
+		b, err := New(tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := b.S3(tt.key); got != tt.want {
+			t.Errorf("%s: S3(%q) = %q, want %q", tt.name, tt.key, got, tt.want)
+		}
+	}
+
+	if _, err := New(Config{PublicBaseURL: "http://localhost:8091", S3Endpoint: "https://"}); err == nil {
+		t.Error("New accepted an S3 endpoint without a host")
+	}
+}
+
+func TestAssetKey(t *testing.T) {
+	tests := []struct {
+		publicBase string
+		rawURL     string
+		want       string
+		wantOK     bool
+	}{
+		{"http://localhost:8091", "http://localhost:8091/assets/thumb.jpg", "thumb.jpg", true},
+		{"http://localhost:8091", "http://old-host:8080/assets/candidates/a.jpg", "candidates/a.jpg", true},
+		{"https://example.com/tubely", "https://example.com/tubely/assets/thumb.jpg", "thumb.jpg", true},
+		{"https://example.com/tubely", "https://example.com/assets/thumb.jpg", "", false},
+		{"http://localhost:8091", "http://localhost:8091/assets/", "", false},
+		{"http://localhost:8091", "http://localhost:8091/app/index.html", "", false},
+		{"http://localhost:8091", "://bad", "", false},
+	}
+	for _, tt := range tests {
+		b, err := New(Config{PublicBaseURL: tt.publicBase})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := b.AssetKey(tt.rawURL)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("AssetKey(%q) under %s = %q, %v; want %q, %v", tt.rawURL, tt.publicBase, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestObjectKey(t *testing.T) {
+	b, err := New(Config{PublicBaseURL: "http://localhost:8091", CDNDomain: "d111.cloudfront.net", Bucket: "tubely"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rawURL string
+		want   string
+		wantOK bool
+	}{
+		{"https://tubely.s3.us-east-2.amazonaws.com/landscape/a.mp4", "landscape/a.mp4", true},
+		{"https://s3.us-east-2.amazonaws.com/tubely/landscape/a.mp4", "landscape/a.mp4", true},
+		{"http://localhost:9000/tubely/hls/out/master.m3u8", "hls/out/master.m3u8", true},
+		{"https://d111.cloudfront.net/landscape/a.mp4", "landscape/a.mp4", true},
+		// On the CDN the first segment is part of the key even if it
+		// happens to match the bucket name.
+		{"https://d111.cloudfront.net/tubely/a.mp4", "tubely/a.mp4", true},
+		{"https://tubely.s3.us-east-2.amazonaws.com/", "", false},
+		{"://bad", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := b.ObjectKey(tt.rawURL)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ObjectKey(%q) = %q, %v; want %q, %v", tt.rawURL, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -2,16 +2,23 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/urlbuilder"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
@@ -20,11 +27,29 @@ type apiConfig struct {
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
-	s3Client         *s3.Client
+	uploadsRoot      string
+	storageBackend   string
+	blobStore        storage.BlobStore
+	assetStore       storage.BlobStore
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
 	port             string
+	adminAPIKey      string
+	gcInterval       time.Duration
+	gcGracePeriod    time.Duration
+	jobTimeout       time.Duration
+	uploadExpiry     time.Duration
+	jobNotify        chan struct{}
+	deletionNotify   chan struct{}
+	hlsLadder        []rendition
+	dashEnabled      bool
+	keepOriginals    bool
+	webpEnabled      bool
+	presignTTL       time.Duration
+	cfSigner         *cfsign.Signer
+	cfCookieDomain   string
+	urls             *urlbuilder.Builder
 }
 
 type thumbnail struct {
@@ -37,15 +62,33 @@ type thumbnail struct {
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
+	// DB_URL takes a postgres:// URL or a SQLite path; DB_PATH is the
+	// older name for the latter.
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	// Migrations are managed before the server's own client opens the
+	// database, since that applies them all.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrateCommand(dbURL, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if !db.FullTextSearch() {
+		log.Print("WARNING: SQLite was built without FTS5, so video search falls back to unranked substring matching. Build with -tags sqlite_fts5, as the Makefile does.")
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -67,32 +110,146 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = filepath.Join(os.TempDir(), "tubely-uploads")
+	}
+
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
+	if s3Bucket == "" && storageBackend == "s3" {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == "s3" {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
-	}
-
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	gcInterval, err := durationFromEnv("GC_INTERVAL", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	gcGracePeriod, err := durationFromEnv("GC_GRACE_PERIOD", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jobTimeout, err := durationFromEnv("JOB_TIMEOUT", 2*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if jobTimeout <= 0 {
+		log.Fatal("JOB_TIMEOUT must be positive")
+	}
+	// Outputs a running job has uploaded aren't referenced by anything until
+	// it finishes, so only their age keeps the collector off them.
+	if gcGracePeriod < jobTimeout {
+		log.Fatalf("GC_GRACE_PERIOD (%s) must be at least JOB_TIMEOUT (%s)", gcGracePeriod, jobTimeout)
+	}
+
+	uploadExpiry, err := durationFromEnv("UPLOAD_EXPIRY", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	videoWorkers, err := intFromEnv("VIDEO_WORKERS", 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hlsLadder, err := parseLadder(os.Getenv("HLS_RENDITIONS"))
+	if err != nil {
+		log.Fatalf("Invalid HLS_RENDITIONS: %v", err)
+	}
+
+	dashEnabled, err := boolFromEnv("DASH_ENABLED", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keepOriginals, err := boolFromEnv("KEEP_ORIGINALS", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	presignTTL, err := durationFromEnv("PRESIGNED_URL_TTL", 15*time.Minute)
 	if err != nil {
-		log.Fatal("Could not auto load the default AWS SDK config")
+		log.Fatal(err)
+	}
+
+	webpEnabled := webpEncoderAvailable()
+	if !webpEnabled {
+		log.Print("ffmpeg has no libwebp encoder; thumbnails will only get JPEG variants")
+	}
+
+	cfSigner, err := cloudFrontSignerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
+
+	publicBaseURL := os.Getenv("PUBLIC_BASE_URL")
+	if publicBaseURL == "" {
+		publicBaseURL = "http://localhost:" + port
+	}
+
+	s3PathStyle, err := boolFromEnv("S3_FORCE_PATH_STYLE", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	urls, err := urlbuilder.New(urlbuilder.Config{
+		PublicBaseURL: publicBaseURL,
+		CDNDomain:     s3CfDistribution,
+		S3Endpoint:    os.Getenv("S3_ENDPOINT"),
+		S3PathStyle:   s3PathStyle,
+		Bucket:        s3Bucket,
+		Region:        s3Region,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Signed URLs and cookies are built on the distribution's domain, so a
+	// key pair is no use without one.
+	if cfSigner != nil && !urls.HasCDN() {
+		log.Fatal("S3_CF_DISTRO environment variable must be set when CF_KEY_PAIR_ID is")
 	}
 
-	myS3Client := s3.NewFromConfig(awsConfig)
+	assetStore := storage.NewLocalStore(assetsRoot, urls.AssetBase())
+
+	var blobStore storage.BlobStore
+	switch storageBackend {
+	case "s3":
+		s3Client, err := s3ClientFromEnv(s3Region, s3PathStyle)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s3Options, err := s3OptionsFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobStore = storage.NewS3Store(s3Client, s3Bucket, s3Options)
+	case "local":
+		blobStore = assetStore
+	case "memory":
+		blobStore = storage.NewMemoryStore()
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected s3, local or memory", storageBackend)
+	}
 
 	cfg := apiConfig{
 		db:               db,
@@ -100,11 +257,29 @@ func main() {
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
-		s3Client:         myS3Client,
+		uploadsRoot:      uploadsRoot,
+		storageBackend:   storageBackend,
+		blobStore:        blobStore,
+		assetStore:       assetStore,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
+		adminAPIKey:      adminAPIKey,
+		gcInterval:       gcInterval,
+		gcGracePeriod:    gcGracePeriod,
+		jobTimeout:       jobTimeout,
+		uploadExpiry:     uploadExpiry,
+		jobNotify:        make(chan struct{}, 1),
+		deletionNotify:   make(chan struct{}, 1),
+		hlsLadder:        hlsLadder,
+		dashEnabled:      dashEnabled,
+		keepOriginals:    keepOriginals,
+		webpEnabled:      webpEnabled,
+		presignTTL:       presignTTL,
+		cfSigner:         cfSigner,
+		cfCookieDomain:   os.Getenv("CF_COOKIE_DOMAIN"),
+		urls:             urls,
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -112,12 +287,35 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = os.MkdirAll(uploadsRoot, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		err = cfg.runCommand(context.Background(), os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = cfg.startVideoWorkers(context.Background(), videoWorkers)
+	if err != nil {
+		log.Fatalf("Couldn't start video workers: %v", err)
+	}
+
+	go cfg.retryPendingDeletions(context.Background())
+	if gcInterval > 0 {
+		go cfg.runGarbageCollector(context.Background())
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", noCacheMiddleware(noSniffMiddleware(assetsHandler)))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
@@ -128,18 +326,131 @@ func main() {
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/presign", cfg.handlerVideoUploadPresign)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/complete", cfg.handlerVideoUploadComplete)
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
+	mux.HandleFunc("OPTIONS /api/uploads/{$}", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/uploads/{$}", cfg.handlerTusCreate)
+	mux.HandleFunc("HEAD /api/uploads/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/uploads/{uploadID}", cfg.handlerTusDelete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnails", cfg.handlerThumbnailCandidatesGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailCandidateSelect)
+	mux.HandleFunc("GET /api/videos/{videoID}/playback", cfg.handlerPlayback)
 	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /admin/gc", cfg.handlerGarbageCollect)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
+	log.Printf("Serving on: %s\n", urls.Public("/app/"))
 	log.Fatal(srv.ListenAndServe())
 }
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 24h: %w", name, err)
+	}
+	return d, nil
+}
+
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+func boolFromEnv(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false: %w", name, err)
+	}
+	return b, nil
+}
+
+// s3ClientFromEnv builds the S3 client from the default AWS config chain,
+// with overrides for S3-compatible stores such as MinIO, Ceph or LocalStack:
+// a custom endpoint, path-style addressing and static credentials.
+func s3ClientFromEnv(region string, pathStyle bool) (*s3.Client, error) {
+	loadOptions := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	accessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+	if (accessKeyID == "") != (secretAccessKey == "") {
+		return nil, fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+	}
+	if accessKeyID != "" {
+		provider := credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, os.Getenv("S3_SESSION_TOKEN"))
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(provider))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the AWS SDK config: %w", err)
+	}
+
+	return s3.NewFromConfig(awsConfig, storage.WithEndpoint(os.Getenv("S3_ENDPOINT"), pathStyle)), nil
+}
+
+func s3OptionsFromEnv() (storage.S3Options, error) {
+	options := storage.DefaultS3Options()
+
+	partSizeMB, err := intFromEnv("S3_PART_SIZE_MB", int(options.PartSize>>20))
+	if err != nil {
+		return options, err
+	}
+	options.PartSize = int64(partSizeMB) << 20
+
+	options.Concurrency, err = intFromEnv("S3_UPLOAD_CONCURRENCY", options.Concurrency)
+	if err != nil {
+		return options, err
+	}
+
+	options.PartRetries, err = intFromEnv("S3_PART_RETRIES", options.PartRetries)
+	if err != nil {
+		return options, err
+	}
+
+	return options, options.Validate()
+}
+
+// cloudFrontSignerFromEnv returns nil when no key pair is configured, in
+// which case video URLs are presigned by the blob store instead.
+func cloudFrontSignerFromEnv() (*cfsign.Signer, error) {
+	keyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	privateKeyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+	if keyPairID == "" && privateKeyPath == "" {
+		return nil, nil
+	}
+	if keyPairID == "" || privateKeyPath == "" {
+		return nil, fmt.Errorf("CF_KEY_PAIR_ID and CF_PRIVATE_KEY_PATH must be set together")
+	}
+	privateKey, err := cfsign.LoadPrivateKey(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load CF_PRIVATE_KEY_PATH: %w", err)
+	}
+	return cfsign.NewSigner(keyPairID, privateKey), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// migrateThumbnails copies every thumbnail and variant still referenced by an
+// /assets/ URL into the blob store under thumbnails/, points the rows at the
+// new keys and then removes the local files. It is safe to run again after a
+// partial failure: rows already holding keys are skipped.
+func (cfg *apiConfig) migrateThumbnails(ctx context.Context, dryRun bool) error {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return err
+	}
+
+	moved, failed := 0, 0
+	for _, video := range videos {
+		// Objects are only removed from the asset store once the rows that
+		// reference them point elsewhere.
+		stale := []string{}
+		move := func(stored string) (string, bool) {
+			ref, ok := cfg.thumbnailRef(stored)
+			if !ok || ref.Store != assetStoreName {
+				return stored, false
+			}
+			key := thumbnailVariantDir(video.ID) + path.Base(ref.Key)
+			log.Printf("Moving %s to %s", ref.Key, key)
+			if dryRun {
+				return stored, false
+			}
+			if err := cfg.copyAssetToBlob(ctx, ref.Key, key); err != nil {
+				log.Printf("Couldn't move %s for video %s: %v", ref.Key, video.ID, err)
+				failed++
+				return stored, false
+			}
+			moved++
+			stale = append(stale, ref.Key)
+			return key, true
+		}
+
+		// Variants are only ever stored alongside a thumbnail.
+		if video.ThumbnailURL == nil {
+			continue
+		}
+		thumbnailURL, changed := move(*video.ThumbnailURL)
+		for i, variant := range video.ThumbnailVariants {
+			if key, ok := move(variant.URL); ok {
+				video.ThumbnailVariants[i].URL = key
+				changed = true
+			}
+		}
+		if changed {
+			if err := cfg.db.SetVideoThumbnail(video.ID, thumbnailURL, video.ThumbnailVariants); err != nil {
+				return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+			}
+		}
+
+		for _, key := range stale {
+			err := cfg.assetStore.Delete(ctx, key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("Couldn't delete %s from assets: %v", key, err)
+			}
+		}
+	}
+
+	log.Printf("Moved %d thumbnails, %d failed", moved, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d thumbnails couldn't be moved", failed)
+	}
+	return nil
+}
+
+// copyAssetToBlob copies one thumbnail, checking the bytes that arrive
+// against those read. Stores take the checksum before the body, so the file
+// is read into memory first; thumbnails are capped well below a size where
+// that matters.
+func (cfg *apiConfig) copyAssetToBlob(ctx context.Context, assetKey, blobKey string) error {
+	body, info, err := cfg.assetStore.Get(ctx, assetKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return cfg.blobStore.Put(ctx, blobKey, bytes.NewReader(data), storage.PutOptions{
+		ContentType:    info.ContentType,
+		Size:           int64(len(data)),
+		ChecksumSHA256: dataChecksum(data),
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// corruptingStore flips the first byte of everything written to it, as a
+// damaged transfer would.
+type corruptingStore struct {
+	storage.BlobStore
+}
+
+func (s corruptingStore) Put(ctx context.Context, key string, body io.Reader, opts storage.PutOptions) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	data[0] ^= 0xff
+	return s.BlobStore.Put(ctx, key, bytes.NewReader(data), opts)
+}
+
+func TestCopyAssetToBlob(t *testing.T) {
+	cfg := newTestConfig(t)
+	ctx := context.Background()
+	data := []byte("\xff\xd8\xff\xe0 thumbnail")
+	if err := cfg.assetStore.Put(ctx, "thumb.jpg", bytes.NewReader(data), storage.PutOptions{ContentType: "image/jpeg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.copyAssetToBlob(ctx, "thumb.jpg", "thumbnails/thumb.jpg"); err != nil {
+		t.Fatalf("copyAssetToBlob: %v", err)
+	}
+	body, info, err := cfg.blobStore.Get(ctx, "thumbnails/thumb.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(copied, data) || info.ContentType != "image/jpeg" {
+		t.Errorf("copied %q as %s, want %q as image/jpeg", copied, info.ContentType, data)
+	}
+
+	cfg.blobStore = corruptingStore{cfg.blobStore}
+	err = cfg.copyAssetToBlob(ctx, "thumb.jpg", "thumbnails/corrupt.jpg")
+	if !errors.Is(err, storage.ErrChecksumMismatch) {
+		t.Errorf("copying through a corrupting store = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := cfg.blobStore.Head(ctx, "thumbnails/corrupt.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("corrupt copy was stored: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sniff"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// originalsPrefix holds uploads exactly as received when KEEP_ORIGINALS is
+// set, one per video, so a better transcode can be made later.
+const originalsPrefix = "originals/"
+
+func originalsDir(videoID uuid.UUID) string {
+	return originalsPrefix + videoID.String() + "/"
+}
+
+func (cfg *apiConfig) storeOriginal(ctx context.Context, videoID uuid.UUID, filePath string) error {
+	checksum, err := fileChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := sniff.ReadHeader(file)
+	if err != nil {
+		return err
+	}
+	contentType := sniff.Detect(header)
+
+	return cfg.blobStore.Put(ctx, originalsDir(videoID)+"original"+videoFormats[contentType].extension, file, storage.PutOptions{
+		ContentType:    contentType,
+		Size:           stat.Size(),
+		ChecksumSHA256: checksum,
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const thumbnailCandidatePrefix = thumbnailVariantPrefix + "candidates/"
+
+// Points in the video, as fractions of its duration, that frames are
+// extracted from. The ends are skipped since they're often black.
+var thumbnailCandidatePositions = []float64{0.1, 0.25, 0.5, 0.75, 0.9}
+
+// thumbnailCandidateTimes returns the distinct offsets, in seconds, to take
+// candidates from. Without a known duration that's just the first frame.
+func thumbnailCandidateTimes(duration float64) []float64 {
+	if duration <= 0 {
+		return []float64{0}
+	}
+	times := []float64{}
+	for _, position := range thumbnailCandidatePositions {
+		// extractFrame seeks to the millisecond, so very short videos can map
+		// several positions onto the same frame.
+		seconds := math.Round(duration*position*1000) / 1000
+		if len(times) > 0 && times[len(times)-1] == seconds {
+			continue
+		}
+		times = append(times, seconds)
+	}
+	return times
+}
+
+func thumbnailCandidateDir(videoID uuid.UUID) string {
+	return thumbnailCandidatePrefix + videoID.String() + "/"
+}
+
+// generateThumbnailCandidates extracts frames at fixed points of the video,
+// stores them as candidates and, if the owner hasn't uploaded a thumbnail,
+// picks the best one as the default.
+func (cfg *apiConfig) generateThumbnailCandidates(ctx context.Context, video *database.Video, filePath string, probe videoProbe) error {
+	outputDir, err := os.MkdirTemp(cfg.uploadsRoot, "thumbnails-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outputDir)
+
+	params := []database.CreateThumbnailCandidateParams{}
+	bestSize := int64(-1)
+	var best, bestPath string
+	for i, seconds := range thumbnailCandidateTimes(probe.Duration) {
+		framePath := filepath.Join(outputDir, fmt.Sprintf("%d.jpg", i))
+		// A frame past the last keyframe or in a damaged stretch shouldn't
+		// cost the candidates that did extract.
+		if err := extractFrame(ctx, filePath, framePath, seconds); err != nil {
+			log.Printf("Couldn't extract thumbnail candidate at %.3fs for video %s: %v", seconds, video.ID, err)
+			continue
+		}
+
+		checksum, err := fileChecksum(framePath)
+		if err != nil {
+			return err
+		}
+		frame, err := os.Open(framePath)
+		if err != nil {
+			return err
+		}
+		stat, err := frame.Stat()
+		if err != nil {
+			frame.Close()
+			return err
+		}
+
+		name := make([]byte, 16)
+		rand.Read(name)
+		key := thumbnailCandidateDir(video.ID) + base64.RawURLEncoding.EncodeToString(name) + ".jpg"
+		err = cfg.blobStore.Put(ctx, key, frame, storage.PutOptions{
+			ContentType:    "image/jpeg",
+			Size:           stat.Size(),
+			ChecksumSHA256: checksum,
+		})
+		frame.Close()
+		if err != nil {
+			return err
+		}
+
+		params = append(params, database.CreateThumbnailCandidateParams{
+			VideoID:         video.ID,
+			URL:             key,
+			PositionSeconds: seconds,
+		})
+
+		// JPEG size is a cheap stand-in for visual detail: black frames and
+		// fades compress to almost nothing.
+		if stat.Size() > bestSize {
+			bestSize = stat.Size()
+			best = key
+			bestPath = framePath
+		}
+	}
+
+	if len(params) == 0 {
+		return errors.New("no frames could be extracted")
+	}
+
+	bestData, err := os.ReadFile(bestPath)
+	if err != nil {
+		return err
+	}
+
+	previous, err := cfg.db.ReplaceThumbnailCandidates(video.ID, params)
+	if err != nil {
+		return err
+	}
+
+	// The owner may have picked or uploaded a thumbnail while the job ran,
+	// so go by what's stored now rather than the video the job started with.
+	current, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	video.ThumbnailURL = current.ThumbnailURL
+	video.ThumbnailVariants = current.ThumbnailVariants
+
+	for _, candidate := range previous {
+		if video.ThumbnailURL != nil && *video.ThumbnailURL == candidate.URL {
+			continue
+		}
+		ref, ok := cfg.thumbnailRef(candidate.URL)
+		if !ok {
+			continue
+		}
+		store, err := cfg.storeByName(ref.Store)
+		if err != nil {
+			return err
+		}
+		if err := store.Delete(ctx, ref.Key); err != nil {
+			log.Printf("Couldn't delete old thumbnail candidate %s: %v", ref.Key, err)
+		}
+	}
+
+	if video.ThumbnailURL == nil {
+		variants, err := cfg.storeThumbnailVariants(ctx, video.ID, bestData)
+		if err != nil {
+			return err
+		}
+		set, err := cfg.db.SetDefaultThumbnail(video.ID, best, variants)
+		if err != nil {
+			return err
+		}
+		if !set {
+			// An upload landed between the read above and now.
+			for _, variant := range variants {
+				if err := cfg.blobStore.Delete(ctx, variant.URL); err != nil {
+					log.Printf("Couldn't delete unused thumbnail variant %s: %v", variant.URL, err)
+				}
+			}
+			return nil
+		}
+		video.ThumbnailURL = &best
+		video.ThumbnailVariants = variants
+	}
+	return nil
+}
+
+// candidateThumbnailVariants builds the srcset variants for a candidate the
+// owner picked; the candidate itself stays as thumbnail_url.
+func (cfg *apiConfig) candidateThumbnailVariants(ctx context.Context, candidate database.ThumbnailCandidate) (database.ThumbnailVariants, error) {
+	ref, ok := cfg.thumbnailRef(candidate.URL)
+	if !ok {
+		return nil, fmt.Errorf("candidate %s has no stored image", candidate.ID)
+	}
+	store, err := cfg.storeByName(ref.Store)
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := store.Get(ctx, ref.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.storeThumbnailVariants(ctx, candidate.VideoID, data)
+}
+
+func extractFrame(ctx context.Context, filePath, outputPath string, seconds float64) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-i", filePath,
+		"-frames:v", "1",
+		"-vf", "scale='min(1280,iw)':-2",
+		"-q:v", "3",
+		outputPath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg frame extraction failed with error: %v\n and stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestThumbnailCandidateTimes(t *testing.T) {
+	tests := []struct {
+		duration float64
+		want     []float64
+	}{
+		{100, []float64{10, 25, 50, 75, 90}},
+		{0, []float64{0}},
+		{-1, []float64{0}},
+		{0.004, []float64{0, 0.001, 0.002, 0.003, 0.004}},
+		{0.002, []float64{0, 0.001, 0.002}},
+		{0.0001, []float64{0}},
+	}
+	for _, tt := range tests {
+		got := thumbnailCandidateTimes(tt.duration)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("thumbnailCandidateTimes(%v) = %v, want %v", tt.duration, got, tt.want)
+		}
+	}
+}
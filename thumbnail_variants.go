@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os/exec"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+const thumbnailVariantPrefix = "thumbnails/"
+
+// thumbnailWidths are the srcset widths produced for every thumbnail. Widths
+// larger than the source are skipped rather than upscaled.
+var thumbnailWidths = []int{320, 640, 1280}
+
+func thumbnailVariantDir(videoID uuid.UUID) string {
+	return thumbnailVariantPrefix + videoID.String() + "/"
+}
+
+// storeThumbnailVariants decodes an image, applies and drops its EXIF
+// orientation, and stores a JPEG copy at each width, plus a WebP one when
+// ffmpeg has libwebp. Re-encoding also discards any other metadata the
+// original carried.
+func (cfg *apiConfig) storeThumbnailVariants(ctx context.Context, videoID uuid.UUID, data []byte) (database.ThumbnailVariants, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode thumbnail: %w", err)
+	}
+
+	widths := []int{}
+	for _, width := range thumbnailWidths {
+		if width <= img.Bounds().Dx() {
+			widths = append(widths, width)
+		}
+	}
+	if len(widths) == 0 {
+		widths = append(widths, img.Bounds().Dx())
+	}
+
+	name := make([]byte, 16)
+	rand.Read(name)
+	base := thumbnailVariantDir(videoID) + base64.RawURLEncoding.EncodeToString(name)
+
+	variants := database.ThumbnailVariants{}
+	for _, width := range widths {
+		resized := imaging.Resize(img, width, 0, imaging.Lanczos)
+		// JPEG has no alpha channel, so flatten transparent PNGs onto white.
+		flattened := imaging.Overlay(imaging.New(resized.Bounds().Dx(), resized.Bounds().Dy(), color.White), resized, image.Pt(0, 0), 1)
+
+		var jpegData bytes.Buffer
+		if err := jpeg.Encode(&jpegData, flattened, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		variant, err := cfg.putThumbnailVariant(ctx, fmt.Sprintf("%s_%d.jpg", base, width), "image/jpeg", jpegData.Bytes(), resized.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+
+		// The standard library has no WebP encoder, so this goes through
+		// ffmpeg, if startup found one built with libwebp.
+		if !cfg.webpEnabled {
+			continue
+		}
+		webpData, err := encodeWebP(ctx, resized)
+		if err != nil {
+			log.Printf("Couldn't encode WebP thumbnail for video %s: %v", videoID, err)
+			continue
+		}
+		variant, err = cfg.putThumbnailVariant(ctx, fmt.Sprintf("%s_%d.webp", base, width), "image/webp", webpData, resized.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+func (cfg *apiConfig) putThumbnailVariant(ctx context.Context, key, contentType string, data []byte, bounds image.Rectangle) (database.ThumbnailVariant, error) {
+	err := cfg.blobStore.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType:    contentType,
+		Size:           int64(len(data)),
+		ChecksumSHA256: dataChecksum(data),
+	})
+	if err != nil {
+		return database.ThumbnailVariant{}, err
+	}
+	return database.ThumbnailVariant{
+		URL:         key,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		ContentType: contentType,
+	}, nil
+}
+
+// webpEncoderAvailable reports whether the ffmpeg on PATH was built with
+// libwebp, which encodeWebP needs.
+func webpEncoderAvailable() bool {
+	output, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(output, []byte(" libwebp "))
+}
+
+func encodeWebP(ctx context.Context, img image.Image) ([]byte, error) {
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-f", "png_pipe", "-i", "pipe:0",
+		"-c:v", "libwebp",
+		"-quality", "80",
+		"-f", "webp", "pipe:1",
+	)
+	var output, stderr bytes.Buffer
+	cmd.Stdin = &input
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg webp encoding failed with error: %v\n and stderr: %s", err, stderr.String())
+	}
+	return output.Bytes(), nil
+}
+
+// largestJPEG is the variant used as thumbnail_url for clients that don't
+// read the variant list.
+func largestJPEG(variants database.ThumbnailVariants) (database.ThumbnailVariant, bool) {
+	largest := database.ThumbnailVariant{}
+	for _, variant := range variants {
+		if variant.ContentType == "image/jpeg" && variant.Width > largest.Width {
+			largest = variant
+		}
+	}
+	return largest, largest.URL != ""
+}
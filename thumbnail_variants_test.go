@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestStoreThumbnailVariantsWithoutWebP(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.webpEnabled = false
+
+	tests := []struct {
+		width, height int
+		wantWidths    []int
+	}{
+		{1600, 900, []int{320, 640, 1280}},
+		{700, 700, []int{320, 640}},
+		{200, 100, []int{200}},
+	}
+	for _, tt := range tests {
+		var data bytes.Buffer
+		if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))); err != nil {
+			t.Fatal(err)
+		}
+		variants, err := cfg.storeThumbnailVariants(context.Background(), uuid.New(), data.Bytes())
+		if err != nil {
+			t.Fatalf("%dx%d: %v", tt.width, tt.height, err)
+		}
+		widths := []int{}
+		for _, variant := range variants {
+			if variant.ContentType != "image/jpeg" {
+				t.Errorf("%dx%d: got a %s variant without a WebP encoder", tt.width, tt.height, variant.ContentType)
+			}
+			if _, _, err := cfg.blobStore.Get(context.Background(), variant.URL); err != nil {
+				t.Errorf("%dx%d: variant %s wasn't stored: %v", tt.width, tt.height, variant.URL, err)
+			}
+			widths = append(widths, variant.Width)
+		}
+		if !reflect.DeepEqual(widths, tt.wantWidths) {
+			t.Errorf("%dx%d: widths = %v, want %v", tt.width, tt.height, widths, tt.wantWidths)
+		}
+	}
+}
+
+func TestLargestJPEG(t *testing.T) {
+	tests := []struct {
+		variants database.ThumbnailVariants
+		want     string
+	}{
+		{nil, ""},
+		{database.ThumbnailVariants{{URL: "a.webp", Width: 640, ContentType: "image/webp"}}, ""},
+		{database.ThumbnailVariants{
+			{URL: "a_320.jpg", Width: 320, ContentType: "image/jpeg"},
+			{URL: "a_640.webp", Width: 640, ContentType: "image/webp"},
+			{URL: "a_640.jpg", Width: 640, ContentType: "image/jpeg"},
+		}, "a_640.jpg"},
+	}
+	for _, tt := range tests {
+		got, ok := largestJPEG(tt.variants)
+		if got.URL != tt.want || ok != (tt.want != "") {
+			t.Errorf("largestJPEG(%v) = %q, %v; want %q", tt.variants, got.URL, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sniff"
+	_ "golang.org/x/image/webp"
+)
+
+// errUnsupportedMedia marks uploads rejected for what they contain, as
+// opposed to failures while checking them.
+var errUnsupportedMedia = errors.New("unsupported media")
+
+var thumbnailExtensions = map[string]string{
+	"image/jpeg": ".jpeg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// videoFormats maps the containers we accept to the ffprobe demuxer that has
+// to agree with the sniffed type, and to the extension of a retained original.
+var videoFormats = map[string]struct {
+	demuxer   string
+	extension string
+}{
+	"video/mp4":        {demuxer: "mp4", extension: ".mp4"},
+	"video/quicktime":  {demuxer: "mov", extension: ".mov"},
+	"video/webm":       {demuxer: "webm", extension: ".webm"},
+	"video/x-matroska": {demuxer: "matroska", extension: ".mkv"},
+}
+
+// videoTypeAliases normalises the other names browsers and tools use for the
+// same containers.
+var videoTypeAliases = map[string]string{
+	"video/mov":      "video/quicktime",
+	"video/matroska": "video/x-matroska",
+	"video/mkv":      "video/x-matroska",
+}
+
+func normalizeVideoType(contentType string) string {
+	if alias, ok := videoTypeAliases[contentType]; ok {
+		return alias
+	}
+	return contentType
+}
+
+func isAcceptedVideoType(contentType string) bool {
+	_, ok := videoFormats[normalizeVideoType(contentType)]
+	return ok
+}
+
+// validateVideoFile checks that the file on disk is one of the accepted
+// containers by its magic bytes and by ffprobe, and that it doesn't double as
+// markup a browser could be tricked into rendering. declaredType is the
+// client's claim, if any. It returns the detected type.
+//
+// Unlike thumbnails, videos are only scanned for markup within the window a
+// browser sniffs. Compressed video is effectively random bytes, so a whole
+// gigabyte would turn up short markers like "<svg" by chance.
+func validateVideoFile(filePath, declaredType string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header, err := sniff.ReadPrefix(file, sniff.SniffWindowSize)
+	if err != nil {
+		return "", err
+	}
+	detected := sniff.Detect(header)
+	format, ok := videoFormats[detected]
+	if !ok {
+		return "", fmt.Errorf("%w: file is not an MP4, MOV, WebM or MKV video", errUnsupportedMedia)
+	}
+	// MOV and MP4 are often labelled as each other; both are ISO BMFF.
+	declaredType = normalizeVideoType(declaredType)
+	if declaredType != "" && declaredType != detected && !(isBMFF(declaredType) && isBMFF(detected)) {
+		return "", fmt.Errorf("%w: declared %s but file is %s", errUnsupportedMedia, declaredType, detected)
+	}
+	if sniff.ContainsMarkup(header) {
+		return "", fmt.Errorf("%w: file contains markup", errUnsupportedMedia)
+	}
+
+	probe, err := probeVideo(filePath)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: ffprobe rejected the file: %v", errUnsupportedMedia, err)
+	}
+	if !strings.Contains(probe.FormatName, format.demuxer) {
+		return "", fmt.Errorf("%w: ffprobe reports format %q", errUnsupportedMedia, probe.FormatName)
+	}
+	return detected, nil
+}
+
+func isBMFF(contentType string) bool {
+	return contentType == "video/mp4" || contentType == "video/quicktime"
+}
+
+// validateThumbnail returns the sniffed media type of an uploaded image after
+// checking it matches declaredType, decodes as that format and carries no
+// markup where a browser sniffing it would look. Like videos, only that
+// window is scanned: compressed image data is bound to contain a short
+// marker somewhere in a large file.
+func validateThumbnail(data []byte, declaredType string) (string, error) {
+	detected := sniff.Detect(data)
+	if _, ok := thumbnailExtensions[detected]; !ok {
+		return "", fmt.Errorf("%w: file is not a JPEG, PNG or WebP image", errUnsupportedMedia)
+	}
+	if declaredType != detected {
+		return "", fmt.Errorf("%w: declared %s but file is %s", errUnsupportedMedia, declaredType, detected)
+	}
+	if sniff.ContainsMarkup(data[:min(len(data), sniff.SniffWindowSize)]) {
+		return "", fmt.Errorf("%w: file contains markup", errUnsupportedMedia)
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedMedia, err)
+	}
+	if "image/"+format != detected {
+		return "", fmt.Errorf("%w: decoded as %s", errUnsupportedMedia, format)
+	}
+	return detected, nil
+}
+
+// respondWithValidationError maps a failed validation to 415 when the upload
+// itself is at fault and 500 otherwise.
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedMedia) {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Unsupported media", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't validate upload", err)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/sniff"
+)
+
+func TestValidateThumbnailMarkup(t *testing.T) {
+	encode := func(width, height int) []byte {
+		t.Helper()
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		random := rand.New(rand.NewSource(1))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(random.Intn(256))
+		}
+		var data bytes.Buffer
+		if err := png.Encode(&data, img); err != nil {
+			t.Fatal(err)
+		}
+		return data.Bytes()
+	}
+	small := encode(4, 4)
+	large := encode(64, 64)
+	if len(small) >= sniff.SniffWindowSize-16 || len(large) <= sniff.SniffWindowSize {
+		t.Fatalf("test images are %d and %d bytes", len(small), len(large))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"plain", large, false},
+		{"markup in the sniff window", append(append([]byte{}, small...), "<script>"...), true},
+		// Anything past the window is image data as far as a browser is
+		// concerned, and compressed bytes may well spell a marker.
+		{"marker past the sniff window", append(append([]byte{}, large...), "<svg"...), false},
+	}
+	for _, tt := range tests {
+		got, err := validateThumbnail(tt.data, "image/png")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateThumbnail = %q, %v; want error %v", tt.name, got, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr && !errors.Is(err, errUnsupportedMedia) {
+			t.Errorf("%s: error %v isn't errUnsupportedMedia", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxJobAttempts  = 3
+	jobPollInterval = 5 * time.Second
+)
+
+// enqueueVideoJob hands a fully received upload to the worker pool. The file
+// at inputPath must live under cfg.uploadsRoot so it survives a restart; the
+// worker removes it once the job finishes. inputSHA256 is the hex SHA-256 of
+// the file, used to reuse processed outputs of identical uploads.
+func (cfg *apiConfig) enqueueVideoJob(video database.Video, inputPath, inputSHA256 string) (database.Job, error) {
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		UserID:      video.UserID,
+		InputPath:   inputPath,
+		InputSHA256: inputSHA256,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	select {
+	case cfg.jobNotify <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+func (cfg *apiConfig) newJobInputPath() string {
+	return cfg.uploadPath(uuid.New())
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (cfg *apiConfig) startVideoWorkers(ctx context.Context, workers int) error {
+	err := cfg.db.RequeueInterruptedJobs(maxJobAttempts)
+	if err != nil {
+		return err
+	}
+	for range workers {
+		go cfg.runVideoWorker(ctx)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runVideoWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := cfg.db.ClaimNextJob()
+		if err != nil {
+			log.Printf("Couldn't claim video job: %v", err)
+		}
+		if err == nil && job.ID != uuid.Nil {
+			cfg.runVideoJob(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.jobNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runVideoJob(ctx context.Context, job database.Job) {
+	jobCtx, cancel := context.WithTimeout(ctx, cfg.jobTimeout)
+	err := cfg.processVideoJob(jobCtx, job)
+	cancel()
+	if err != nil {
+		log.Printf("Video job %s failed: %v", job.ID, err)
+		message := err.Error()
+		if err := cfg.db.FinishJob(job.ID, database.JobStatusFailed, &message); err != nil {
+			log.Printf("Couldn't record failure of job %s: %v", job.ID, err)
+		}
+		if err := cfg.db.UpdateVideoStatus(job.VideoID, database.VideoStatusFailed, &message); err != nil {
+			log.Printf("Couldn't record failure of video %s: %v", job.VideoID, err)
+		}
+	} else if err := cfg.db.FinishJob(job.ID, database.JobStatusSucceeded, nil); err != nil {
+		log.Printf("Couldn't record completion of job %s: %v", job.ID, err)
+	}
+
+	if err := os.Remove(job.InputPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Couldn't remove input of job %s: %v", job.ID, err)
+	}
+}
+
+func (cfg *apiConfig) processVideoJob(ctx context.Context, job database.Job) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return errors.New("video was deleted")
+	}
+
+	err = cfg.db.UpdateVideoStatus(video.ID, database.VideoStatusProcessing, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.processVideoUpload(ctx, video, job.InputPath, job.InputSHA256)
+	if err != nil {
+		return err
+	}
+
+	return cfg.db.UpdateVideoStatus(video.ID, database.VideoStatusReady, nil)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type videoProbe struct {
+	// FormatName is ffprobe's demuxer list, e.g. "mov,mp4,m4a,3gp,3g2,mj2".
+	FormatName string
+	Width      int
+	Height     int
+	HasAudio   bool
+	Duration   float64
+	FrameRate  float64
+	VideoCodec string
+	AudioCodec string
+	BitRate    int64
+	// Rotation is the display rotation in degrees, normalised to 0-359.
+	Rotation int
+}
+
+func probeVideo(filePath string) (videoProbe, error) {
+
+	var outBuff bytes.Buffer
+
+	videoData := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
+
+	videoData.Stdout = &outBuff
+
+	err := videoData.Run()
+	if err != nil {
+		return videoProbe{}, err
+	}
+
+	type SideData struct {
+		Rotation float64 `json:"rotation"`
+	}
+
+	type Stream struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []SideData        `json:"side_data_list"`
+	}
+
+	type Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	}
+
+	type ProbeResult struct {
+		Streams []Stream `json:"streams"`
+		Format  Format   `json:"format"`
+	}
+
+	var results ProbeResult
+	err = json.Unmarshal(outBuff.Bytes(), &results)
+	if err != nil {
+		return videoProbe{}, err
+	}
+
+	probe := videoProbe{
+		FormatName: results.Format.FormatName,
+	}
+
+	for _, result := range results.Streams {
+		switch result.CodecType {
+		case "video":
+			if probe.VideoCodec != "" {
+				continue
+			}
+			probe.Height = result.Height
+			probe.Width = result.Width
+			probe.VideoCodec = result.CodecName
+			probe.FrameRate = parseFrameRate(result.AvgFrameRate)
+			// Older ffprobe builds report rotation as a tag, newer ones as
+			// display matrix side data.
+			rotation := 0.0
+			if tag, ok := result.Tags["rotate"]; ok {
+				rotation, _ = strconv.ParseFloat(tag, 64)
+			}
+			for _, sideData := range result.SideDataList {
+				if sideData.Rotation != 0 {
+					rotation = sideData.Rotation
+				}
+			}
+			probe.Rotation = ((int(rotation) % 360) + 360) % 360
+		case "audio":
+			if probe.HasAudio {
+				continue
+			}
+			probe.HasAudio = true
+			probe.AudioCodec = result.CodecName
+		}
+	}
+	if probe.Height == 0 || probe.Width == 0 {
+		return videoProbe{}, errors.New("No valid videos found")
+	}
+	if results.Format.Duration != "" {
+		probe.Duration, err = strconv.ParseFloat(results.Format.Duration, 64)
+		if err != nil {
+			return videoProbe{}, fmt.Errorf("invalid duration %q: %w", results.Format.Duration, err)
+		}
+	}
+	if results.Format.BitRate != "" {
+		probe.BitRate, err = strconv.ParseInt(results.Format.BitRate, 10, 64)
+		if err != nil {
+			return videoProbe{}, fmt.Errorf("invalid bit rate %q: %w", results.Format.BitRate, err)
+		}
+	}
+
+	return probe, nil
+}
+
+// parseFrameRate turns ffprobe's rational frame rates like "30000/1001"
+// into a float, returning 0 when unknown.
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// displaySize is the frame size a player shows, with width and height
+// swapped for videos rotated by a quarter turn (common for phone footage).
+func (p videoProbe) displaySize() (int, int) {
+	if p.Rotation == 90 || p.Rotation == 270 {
+		return p.Height, p.Width
+	}
+	return p.Width, p.Height
+}
+
+func (p videoProbe) metadata(fileSize int64) database.VideoMetadata {
+	width, height := p.displaySize()
+	metadata := database.VideoMetadata{
+		Width:    &width,
+		Height:   &height,
+		Rotation: &p.Rotation,
+		FileSize: &fileSize,
+	}
+	if p.Duration > 0 {
+		metadata.DurationSeconds = &p.Duration
+	}
+	if p.FrameRate > 0 {
+		metadata.FrameRate = &p.FrameRate
+	}
+	if p.VideoCodec != "" {
+		metadata.VideoCodec = &p.VideoCodec
+	}
+	if p.AudioCodec != "" {
+		metadata.AudioCodec = &p.AudioCodec
+	}
+	if p.BitRate > 0 {
+		metadata.BitRate = &p.BitRate
+	}
+	return metadata
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// dbVideoToSignedVideo swaps the stored object keys on a video for signed
+// GET URLs, so the bucket itself can stay private. With a CloudFront key pair
+// configured the URLs point at the distribution, otherwise they are presigned
+// by the blob store.
+//
+// Only the manifest of HLS and DASH output is signed; players fetch segments
+// relative to it. When those fetches can't succeed the manifest URLs are left
+// out, and clients fall back to the MP4 at video_url.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if !cfg.streamsPlayable() {
+		video.HLSURL = nil
+		video.DASHURL = nil
+	}
+	for _, field := range []**string{&video.VideoURL, &video.HLSURL, &video.DASHURL} {
+		if *field == nil {
+			continue
+		}
+		key, ok := cfg.getObjectKey(**field)
+		if !ok {
+			continue
+		}
+		signed, err := cfg.signObjectKey(ctx, key, field != &video.VideoURL)
+		if err != nil {
+			return video, err
+		}
+		*field = &signed
+	}
+
+	if video.ThumbnailURL != nil {
+		signed, err := cfg.signThumbnailURL(ctx, *video.ThumbnailURL)
+		if err != nil {
+			return video, err
+		}
+		video.ThumbnailURL = &signed
+	}
+	variants := make(database.ThumbnailVariants, len(video.ThumbnailVariants))
+	for i, variant := range video.ThumbnailVariants {
+		signed, err := cfg.signThumbnailURL(ctx, variant.URL)
+		if err != nil {
+			return video, err
+		}
+		variant.URL = signed
+		variants[i] = variant
+	}
+	video.ThumbnailVariants = variants
+	return video, nil
+}
+
+// streamsPlayable reports whether players can fetch HLS and DASH segments
+// next to a signed manifest. CloudFront manifests are signed over their whole
+// directory, and the local store serves everything publicly, but a presigned
+// S3 URL covers the manifest alone and segment requests to the private bucket
+// are refused.
+func (cfg *apiConfig) streamsPlayable() bool {
+	return cfg.cfSigner != nil || cfg.storageBackend != "s3"
+}
+
+// signThumbnailURL signs thumbnails kept in the blob store and passes older
+// /assets/ URLs through untouched.
+func (cfg *apiConfig) signThumbnailURL(ctx context.Context, stored string) (string, error) {
+	ref, ok := cfg.thumbnailRef(stored)
+	if !ok || ref.Store != blobStoreName {
+		return stored, nil
+	}
+	return cfg.signObjectKey(ctx, ref.Key, false)
+}
+
+func (cfg *apiConfig) signObjectKey(ctx context.Context, key string, wholeDirectory bool) (string, error) {
+	if cfg.cfSigner != nil {
+		return cfg.signCloudFrontURL(key, wholeDirectory)
+	}
+	return cfg.blobStore.PresignGet(ctx, key, cfg.presignTTL)
+}
+
+// signCloudFrontURL signs a single object with a canned policy. Manifests get
+// a custom policy over their whole directory instead, so players that carry
+// the query string over to segment requests keep working without cookies.
+func (cfg *apiConfig) signCloudFrontURL(key string, wholeDirectory bool) (string, error) {
+	expires := time.Now().Add(cfg.presignTTL)
+	rawURL := cfg.urls.CDN(key)
+	if !wholeDirectory {
+		return cfg.cfSigner.SignCannedURL(rawURL, expires)
+	}
+	resource := cfg.urls.CDN(path.Dir(key) + "/*")
+	return cfg.cfSigner.SignCustomURL(rawURL, cfsign.NewCustomPolicy(resource, expires, time.Time{}, ""))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestSignedVideoStreams(t *testing.T) {
+	cfg := newTestConfig(t)
+	ctx := context.Background()
+	keys := []string{"landscape/video.mp4", "hls/out/master.m3u8", "dash/out/manifest.mpd"}
+	for _, key := range keys {
+		if err := cfg.blobStore.Put(ctx, key, bytes.NewReader([]byte("x")), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	video := database.Video{VideoURL: &keys[0], HLSURL: &keys[1], DASHURL: &keys[2]}
+
+	tests := []struct {
+		backend     string
+		wantStreams bool
+	}{
+		{"s3", false},
+		{"memory", true},
+	}
+	for _, tt := range tests {
+		cfg.storageBackend = tt.backend
+		signed, err := cfg.dbVideoToSignedVideo(ctx, video)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.backend, err)
+		}
+		if signed.VideoURL == nil || !strings.HasSuffix(*signed.VideoURL, keys[0]) {
+			t.Errorf("%s: video_url = %v", tt.backend, signed.VideoURL)
+		}
+		if gotStreams := signed.HLSURL != nil && signed.DASHURL != nil; gotStreams != tt.wantStreams {
+			t.Errorf("%s: hls_url = %v, dash_url = %v, want streams %v", tt.backend, signed.HLSURL, signed.DASHURL, tt.wantStreams)
+		}
+	}
+}